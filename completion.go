@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"sqlite/minisqlite"
+)
+
+var sqlKeywords = []string{
+	"analyze", "and", "as", "avg", "begin", "by", "commit", "count", "create", "end", "explain", "from", "group", "having",
+	"inner", "insert", "into", "join", "left", "max", "min", "not", "null", "on", "or", "outer", "plan", "query",
+	"rollback", "select", "sum", "table", "transaction", "values", "where",
+}
+
+var metaCommands = []string{
+	".bail", ".btree", ".check", ".constants", ".dump", ".exit", ".headers", ".import", ".mode",
+	".nullvalue", ".once", ".output", ".page", ".profile", ".read", ".stats", ".timer",
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// newCompleter 行首的元命令补全命令名, .mode 之后补全输出格式, 其余补全关键字, 表名和列名
+func newCompleter(db *minisqlite.DB) Completer {
+	return func(line []rune, pos int) (int, []string) {
+		before := string(line[:pos])
+		if strings.HasPrefix(before, ".") && !strings.Contains(before, " ") {
+			return 0, matchPrefix(metaCommands, before)
+		}
+
+		start := pos
+		for start > 0 && isWordRune(line[start-1]) {
+			start--
+		}
+		word := string(line[start:pos])
+		if strings.HasPrefix(before, ".mode ") {
+			return start, matchPrefix(modeNames, word)
+		}
+		if word == "" || strings.HasPrefix(before, ".") {
+			return start, nil
+		}
+
+		words := append([]string(nil), sqlKeywords...)
+		tables, _ := db.Tables()
+		words = append(words, tables...)
+		for _, table := range tables {
+			columns, _ := db.Columns(table)
+			words = append(words, columns...)
+		}
+		return start, matchPrefix(words, word)
+	}
+}
+
+// matchPrefix 不区分大小写地匹配前缀, 结果排序并去重
+func matchPrefix(words []string, prefix string) []string {
+	seen := make(map[string]bool)
+	var matches []string
+	for _, word := range words {
+		if strings.HasPrefix(strings.ToLower(word), strings.ToLower(prefix)) && !seen[word] {
+			seen[word] = true
+			matches = append(matches, word)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
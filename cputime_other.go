@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "time"
+
+// 其他平台不统计CPU时间, .timer 只有real时间有意义
+func cpuTime() (user, sys time.Duration) {
+	return 0, 0
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuTime 进程到目前为止使用的用户态和内核态CPU时间
+func cpuTime() (user, sys time.Duration) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+	return time.Duration(usage.Utime.Nano()), time.Duration(usage.Stime.Nano())
+}
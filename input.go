@@ -3,35 +3,158 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+
+	"sqlite/minisqlite"
 )
 
 type InputBuffer struct {
 	buffer       []byte
 	bufferLength int
 	inputLength  int
+
+	// reader 在整个会话中只创建一次, 管道输入时缓冲的内容不会丢失
+	reader *bufio.Reader
+	// editor 标准输入输出都是终端时使用行编辑器, 否则按行读取
+	editor *LineEditor
+	// batch 不显示提示符
+	batch bool
+	// statements 已经读到;但还没有执行的语句, partial 为最后一个;之后未结束的部分
+	statements []string
+	partial    string
+
+	// name 脚本文件名, 出错时和行号一起输出, 标准输入时为空
+	name string
+	// lineNumber 已经读入的行数, line 为当前buffer开始的行,
+	// statementLines 和 partialLine 为statements和partial开始的行
+	lineNumber     int
+	line           int
+	statementLines []int
+	partialLine    int
 }
 
-func newInputBuffer() *InputBuffer {
+func newInputBuffer(input io.Reader) *InputBuffer {
 	return &InputBuffer{
 		buffer:       nil,
 		bufferLength: 0,
 		inputLength:  0,
+		reader:       bufio.NewReader(input),
+	}
+}
+
+// readInput 读取下一条元命令或以;结束的语句, 语句不包含结尾的;
+// 语句没有结束时以 ...> 提示继续输入, 一行中可以有多条语句.
+// 输入结束时最后没有;的语句也会执行, 之后返回io.EOF
+func (inputBuffer *InputBuffer) readInput() error {
+	for len(inputBuffer.statements) == 0 {
+		line, err := inputBuffer.readLine(promptText(inputBuffer.partial != ""))
+		if err == errInterrupt {
+			inputBuffer.partial = ""
+			continue
+		}
+		if err == io.EOF && line == "" {
+			if inputBuffer.partial == "" {
+				return io.EOF
+			}
+			inputBuffer.statements = append(inputBuffer.statements, strings.TrimSpace(inputBuffer.partial))
+			inputBuffer.statementLines = append(inputBuffer.statementLines, inputBuffer.partialLine)
+			inputBuffer.partial = ""
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		inputBuffer.lineNumber++
+
+		// 元命令只占一行, 不需要;
+		if inputBuffer.partial == "" && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			inputBuffer.setBuffer(strings.TrimSpace(line))
+			inputBuffer.line = inputBuffer.lineNumber
+			return nil
+		}
+
+		if inputBuffer.partial == "" {
+			inputBuffer.partialLine = inputBuffer.lineNumber
+		}
+		var statements []string
+		statements, inputBuffer.partial = splitStatements(inputBuffer.partial + line + "\n")
+		for i, statement := range statements {
+			if statement = strings.TrimSpace(statement); statement != "" {
+				// 同一行中的第二条语句从这一行开始
+				startLine := inputBuffer.lineNumber
+				if i == 0 {
+					startLine = inputBuffer.partialLine
+				}
+				inputBuffer.statements = append(inputBuffer.statements, statement)
+				inputBuffer.statementLines = append(inputBuffer.statementLines, startLine)
+			}
+		}
+		if len(statements) > 0 {
+			inputBuffer.partialLine = inputBuffer.lineNumber
+		}
+		if strings.TrimSpace(inputBuffer.partial) == "" {
+			inputBuffer.partial = ""
+		}
 	}
+
+	inputBuffer.setBuffer(inputBuffer.statements[0])
+	inputBuffer.line = inputBuffer.statementLines[0]
+	inputBuffer.statements = inputBuffer.statements[1:]
+	inputBuffer.statementLines = inputBuffer.statementLines[1:]
+	return nil
 }
 
-func (inputBuffer *InputBuffer) readInput() {
-	var err error
-	inputBuffer.buffer, _, err = bufio.NewReader(os.Stdin).ReadLine()
-	if err != nil {
-		fmt.Printf("Error reading input: %s\n", err.Error())
-		os.Exit(EXIT_FAILURE)
+// enableLineEditor 在终端中使用行编辑器, 补全时查询db中的表名
+func (inputBuffer *InputBuffer) enableLineEditor(db *minisqlite.DB) {
+	if isTerminal(os.Stdin.Fd()) && isTerminal(os.Stdout.Fd()) {
+		inputBuffer.editor = newLineEditor(inputBuffer.reader, historyPath(), newCompleter(db))
 	}
+}
+
+func (inputBuffer *InputBuffer) readLine(prompt string) (string, error) {
+	if inputBuffer.editor != nil {
+		return inputBuffer.editor.readLine(prompt)
+	}
+	if !inputBuffer.batch {
+		fmt.Printf("%s", prompt)
+	}
+	return inputBuffer.reader.ReadString('\n')
+}
+
+// location 脚本中出错时输出的"文件名:行号: "前缀
+func (inputBuffer *InputBuffer) location() string {
+	if inputBuffer.name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", inputBuffer.name, inputBuffer.line)
+}
+
+func (inputBuffer *InputBuffer) setBuffer(input string) {
+	inputBuffer.buffer = []byte(input)
+	inputBuffer.bufferLength = len(input)
+	inputBuffer.inputLength = len(input)
+}
 
-	if len(inputBuffer.buffer) <= 0 {
-		fmt.Printf("Error reading input\n")
-		os.Exit(EXIT_FAILURE)
+// splitStatements 在字符串字面量之外的;处切分, rest 为最后一个;之后的部分
+func splitStatements(input string) (statements []string, rest string) {
+	inString := false
+	start := 0
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '\'':
+			// 字面量中的''会连续切换两次, 不需要特殊处理
+			inString = !inString
+		case ';':
+			if !inString {
+				statements = append(statements, input[start:i])
+				start = i + 1
+			}
+		}
 	}
+	return statements, input[start:]
 }
 
 func (inputBuffer *InputBuffer) closeInputBuffer() {
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 控制键
+const (
+	KEY_CTRL_A    = 1
+	KEY_CTRL_B    = 2
+	KEY_CTRL_C    = 3
+	KEY_CTRL_D    = 4
+	KEY_CTRL_E    = 5
+	KEY_CTRL_F    = 6
+	KEY_CTRL_G    = 7
+	KEY_CTRL_H    = 8
+	KEY_TAB       = 9
+	KEY_CTRL_K    = 11
+	KEY_CTRL_L    = 12
+	KEY_ENTER     = 13
+	KEY_CTRL_N    = 14
+	KEY_CTRL_P    = 16
+	KEY_CTRL_R    = 18
+	KEY_CTRL_U    = 21
+	KEY_CTRL_W    = 23
+	KEY_ESC       = 27
+	KEY_BACKSPACE = 127
+)
+
+// 转义序列解码后的按键, 取负值不会和输入的字符冲突
+const (
+	KEY_UP = -(iota + 1)
+	KEY_DOWN
+	KEY_RIGHT
+	KEY_LEFT
+	KEY_HOME
+	KEY_END
+	KEY_DELETE
+	KEY_UNKNOWN
+)
+
+const (
+	HISTORY_FILE_NAME = ".minisqlite_history"
+	HISTORY_MAX_LINES = 1000
+)
+
+// errInterrupt 输入行时按了Ctrl-C, 丢弃正在输入的语句
+var errInterrupt = errors.New("interrupted")
+
+// Completer 返回光标前需要补全的词的起始位置和候选词
+type Completer func(line []rune, pos int) (start int, candidates []string)
+
+// LineEditor 终端的行编辑器: 光标移动和删除, 上下键翻历史, Ctrl-R反向搜索历史, Tab补全.
+// 只在读一行时把终端切换到raw mode, 执行语句时恢复原来的设置.
+type LineEditor struct {
+	fd          uintptr
+	reader      *bufio.Reader
+	out         io.Writer
+	history     []string
+	historyFile string
+	completer   Completer
+
+	prompt string
+	line   []rune
+	pos    int
+}
+
+// newLineEditor historyFile为空时历史不保存到文件
+func newLineEditor(reader *bufio.Reader, historyFile string, completer Completer) *LineEditor {
+	editor := &LineEditor{
+		fd:          os.Stdin.Fd(),
+		reader:      reader,
+		out:         os.Stdout,
+		historyFile: historyFile,
+		completer:   completer,
+	}
+	editor.loadHistory()
+	return editor
+}
+
+// historyPath 历史文件在用户的主目录下
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home + string(os.PathSeparator) + HISTORY_FILE_NAME
+}
+
+// loadHistory 读入历史文件中最后HISTORY_MAX_LINES行, 文件过长时重写, 读写失败时不使用历史文件
+func (editor *LineEditor) loadHistory() {
+	if editor.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(editor.historyFile)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			editor.history = append(editor.history, line)
+		}
+	}
+	if len(editor.history) > HISTORY_MAX_LINES {
+		editor.history = editor.history[len(editor.history)-HISTORY_MAX_LINES:]
+		os.WriteFile(editor.historyFile, []byte(strings.Join(editor.history, "\n")+"\n"), 0600)
+	}
+}
+
+// addHistory 每输入一行立即追加到历史文件, 不依赖正常退出
+func (editor *LineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(editor.history) > 0 && editor.history[len(editor.history)-1] == line) {
+		return
+	}
+	editor.history = append(editor.history, line)
+	if editor.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(editor.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s\n", line)
+}
+
+// readKey 读一个字符, 方向键等转义序列解码为KEY_UP等
+func (editor *LineEditor) readKey() (rune, error) {
+	r, _, err := editor.reader.ReadRune()
+	if err != nil || r != KEY_ESC {
+		return r, err
+	}
+
+	b, err := editor.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != '[' && b != 'O' {
+		return KEY_UNKNOWN, nil
+	}
+	b, err = editor.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch b {
+	case 'A':
+		return KEY_UP, nil
+	case 'B':
+		return KEY_DOWN, nil
+	case 'C':
+		return KEY_RIGHT, nil
+	case 'D':
+		return KEY_LEFT, nil
+	case 'H':
+		return KEY_HOME, nil
+	case 'F':
+		return KEY_END, nil
+	}
+	if b < '0' || b > '9' {
+		return KEY_UNKNOWN, nil
+	}
+
+	// ESC [ n ~ 形式, 例如 ESC [ 3 ~ 为Delete
+	number := string(b)
+	for {
+		b, err = editor.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < '0' || b > '9' {
+			break
+		}
+		number += string(b)
+	}
+	if b != '~' {
+		return KEY_UNKNOWN, nil
+	}
+	switch number {
+	case "1", "7":
+		return KEY_HOME, nil
+	case "4", "8":
+		return KEY_END, nil
+	case "3":
+		return KEY_DELETE, nil
+	}
+	return KEY_UNKNOWN, nil
+}
+
+// readLine 显示prompt读入一行, 不包含换行符. 空行时Ctrl-D返回io.EOF, Ctrl-C返回errInterrupt
+func (editor *LineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(editor.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	editor.prompt, editor.line, editor.pos = prompt, nil, 0
+	// historyIndex 等于len(history)时是正在输入的新行, 翻历史前保存在edited中
+	historyIndex := len(editor.history)
+	var edited []rune
+	editor.refresh()
+
+	var pending rune
+	for {
+		key := pending
+		pending = 0
+		if key == 0 {
+			if key, err = editor.readKey(); err != nil {
+				return "", err
+			}
+		}
+
+		switch key {
+		case KEY_ENTER, '\n':
+			fmt.Fprintf(editor.out, "\r\n")
+			line := string(editor.line)
+			editor.addHistory(line)
+			return line, nil
+		case KEY_CTRL_C:
+			fmt.Fprintf(editor.out, "^C\r\n")
+			return "", errInterrupt
+		case KEY_CTRL_D:
+			if len(editor.line) == 0 {
+				fmt.Fprintf(editor.out, "\r\n")
+				return "", io.EOF
+			}
+			editor.delete(editor.pos, editor.pos+1)
+		case KEY_BACKSPACE, KEY_CTRL_H:
+			if editor.pos > 0 {
+				editor.delete(editor.pos-1, editor.pos)
+			}
+		case KEY_DELETE:
+			editor.delete(editor.pos, editor.pos+1)
+		case KEY_LEFT, KEY_CTRL_B:
+			if editor.pos > 0 {
+				editor.pos--
+			}
+		case KEY_RIGHT, KEY_CTRL_F:
+			if editor.pos < len(editor.line) {
+				editor.pos++
+			}
+		case KEY_HOME, KEY_CTRL_A:
+			editor.pos = 0
+		case KEY_END, KEY_CTRL_E:
+			editor.pos = len(editor.line)
+		case KEY_CTRL_K:
+			editor.delete(editor.pos, len(editor.line))
+		case KEY_CTRL_U:
+			editor.delete(0, editor.pos)
+		case KEY_CTRL_W:
+			start := editor.pos
+			for start > 0 && editor.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && editor.line[start-1] != ' ' {
+				start--
+			}
+			editor.delete(start, editor.pos)
+		case KEY_CTRL_L:
+			fmt.Fprintf(editor.out, "\x1b[H\x1b[2J")
+		case KEY_UP, KEY_CTRL_P:
+			if historyIndex > 0 {
+				if historyIndex == len(editor.history) {
+					edited = editor.line
+				}
+				historyIndex--
+				editor.setLine([]rune(editor.history[historyIndex]))
+			}
+		case KEY_DOWN, KEY_CTRL_N:
+			if historyIndex < len(editor.history) {
+				historyIndex++
+				if historyIndex == len(editor.history) {
+					editor.setLine(edited)
+				} else {
+					editor.setLine([]rune(editor.history[historyIndex]))
+				}
+			}
+		case KEY_TAB:
+			editor.complete()
+		case KEY_CTRL_R:
+			if pending, err = editor.reverseSearch(); err != nil {
+				return "", err
+			}
+			historyIndex = len(editor.history)
+		default:
+			if key >= ' ' {
+				editor.insert(key)
+			}
+		}
+		editor.refresh()
+	}
+}
+
+// refresh 重新绘制提示符和当前行, 把光标移到pos
+func (editor *LineEditor) refresh() {
+	fmt.Fprintf(editor.out, "\r%s%s\x1b[K", editor.prompt, string(editor.line))
+	if tail := len(editor.line) - editor.pos; tail > 0 {
+		fmt.Fprintf(editor.out, "\x1b[%dD", tail)
+	}
+}
+
+func (editor *LineEditor) setLine(line []rune) {
+	editor.line = append([]rune(nil), line...)
+	editor.pos = len(editor.line)
+}
+
+func (editor *LineEditor) insert(r rune) {
+	editor.line = append(editor.line[:editor.pos], append([]rune{r}, editor.line[editor.pos:]...)...)
+	editor.pos++
+}
+
+// delete 删除[start, end), 超出行尾的部分忽略
+func (editor *LineEditor) delete(start, end int) {
+	if end > len(editor.line) {
+		end = len(editor.line)
+	}
+	if start >= end {
+		return
+	}
+	editor.line = append(editor.line[:start], editor.line[end:]...)
+	if editor.pos > end {
+		editor.pos -= end - start
+	} else if editor.pos > start {
+		editor.pos = start
+	}
+}
+
+// complete 只有一个候选词时补全并加一个空格, 多个时补全到共同前缀, 不能再补全时列出所有候选词
+func (editor *LineEditor) complete() {
+	if editor.completer == nil {
+		return
+	}
+	start, candidates := editor.completer(editor.line, editor.pos)
+	if len(candidates) == 0 {
+		fmt.Fprintf(editor.out, "\a")
+		return
+	}
+
+	word := editor.line[start:editor.pos]
+	completion := candidates[0] + " "
+	if len(candidates) > 1 {
+		completion = commonPrefix(candidates)
+	}
+	if len([]rune(completion)) > len(word) {
+		rest := append([]rune(completion), editor.line[editor.pos:]...)
+		editor.line = append(editor.line[:start], rest...)
+		editor.pos = start + len([]rune(completion))
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(editor.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// reverseSearch Ctrl-R 在历史中从新到旧查找包含输入内容的行, 再按Ctrl-R找更早的一行,
+// Ctrl-G 取消并恢复原来的行, 其他控制键接受找到的行, 返回该按键继续由readLine处理
+func (editor *LineEditor) reverseSearch() (rune, error) {
+	original, originalPos := editor.line, editor.pos
+	var query []rune
+	index := len(editor.history)
+	found := true
+
+	search := func(from int) {
+		if from >= len(editor.history) {
+			from = len(editor.history) - 1
+		}
+		for i := from; i >= 0; i-- {
+			if strings.Contains(editor.history[i], string(query)) {
+				index, found = i, true
+				editor.setLine([]rune(editor.history[i]))
+				return
+			}
+		}
+		found = false
+	}
+
+	for {
+		status := "reverse-i-search"
+		if !found {
+			status = "failing reverse-i-search"
+		}
+		fmt.Fprintf(editor.out, "\r(%s)`%s': %s\x1b[K", status, string(query), string(editor.line))
+
+		key, err := editor.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key == KEY_CTRL_R:
+			if index > 0 {
+				search(index - 1)
+			}
+		case key == KEY_BACKSPACE || key == KEY_CTRL_H:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				search(len(editor.history))
+			}
+		case key == KEY_CTRL_G || key == KEY_CTRL_C:
+			editor.line, editor.pos = original, originalPos
+			return 0, nil
+		case key >= ' ':
+			query = append(query, key)
+			search(index)
+		default:
+			return key, nil
+		}
+	}
+}
@@ -1,68 +1,184 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
+
+	"sqlite/minisqlite"
 )
 
 func main() {
-	argc := os.Args
-	if len(argc) < 2 {
+	command := flag.String("c", "", "execute `statements` and exit")
+	script := flag.String("f", "", "read statements from `file` and exit")
+	batch := flag.Bool("batch", false, "no prompts and no \"Executed.\" messages")
+	bail := flag.Bool("bail", false, "stop after the first error with a non-zero exit status")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] FILENAME\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		fmt.Printf("Must supply a database filename.\n")
 		os.Exit(EXIT_FAILURE)
 	}
 
-	fileName := argc[1]
-	table := dbOpen(fileName)
+	fileName := flag.Arg(0)
+	db, err := minisqlite.Open(fileName, nil)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(EXIT_FAILURE)
+	}
+
+	shell := &Shell{db: db, settings: newOutputSettings(), batch: *batch, bail: *bail}
 
-	inputBuffer := newInputBuffer()
+	// -c 和 -f 执行完后退出, 不显示提示符
+	var input io.Reader = os.Stdin
+	if *command != "" {
+		input = strings.NewReader(*command)
+		shell.batch = true
+	} else if *script != "" {
+		file, err := os.Open(*script)
+		if err != nil {
+			fmt.Printf("Error: cannot open \"%s\"\n", *script)
+			shell.exit(EXIT_FAILURE)
+		}
+		defer file.Close()
+		input = file
+		shell.batch = true
+	}
+
+	inputBuffer := newInputBuffer(input)
+	inputBuffer.batch = shell.batch
+	inputBuffer.name = *script
+	if input == os.Stdin && !shell.batch {
+		inputBuffer.enableLineEditor(db)
+	}
+
+	if !shell.run(inputBuffer) {
+		shell.exit(EXIT_FAILURE)
+	}
+	shell.exit(EXIT_SUCCESS)
+}
+
+// Shell 命令行的状态, 执行元命令和语句
+type Shell struct {
+	db       *minisqlite.DB
+	settings *OutputSettings
+	// batch 不显示提示符和"Executed.", bail 出错后立即以失败状态退出
+	batch bool
+	bail  bool
+	// readDepth 正在执行的嵌套 .read 层数
+	readDepth int
+	// timer 每条语句之后输出用时, profile 输出读写的页面和分裂次数
+	timer   bool
+	profile bool
+}
 
+// run 逐条执行输入中的元命令和语句, 输入结束时返回true.
+// -bail 时出错后立即返回false, 不再执行之后的内容
+func (shell *Shell) run(inputBuffer *InputBuffer) bool {
 	for {
-		printPrompt()
-		inputBuffer.readInput()
+		if err := inputBuffer.readInput(); err != nil {
+			if err != io.EOF {
+				fmt.Printf("%sError reading input: %s\n", inputBuffer.location(), err.Error())
+				return false
+			}
+			return true
+		}
 
+		ok := true
 		if inputBuffer.buffer[0] == '.' {
-			switch doMetaCommand(inputBuffer, table) {
+			switch doMetaCommand(inputBuffer, shell) {
 			case META_COMMAND_SUCCESS:
-				continue
 			case META_COMMAND_UNRECOGNIZED_COMMAND:
-				fmt.Printf("Unrecognized command '%s'.\n", string(inputBuffer.buffer))
-				continue
+				fmt.Printf("%sUnrecognized command '%s'.\n", inputBuffer.location(), string(inputBuffer.buffer))
+				ok = false
+			case META_COMMAND_FAILURE:
+				// 错误已经输出, 脚本中再给出出错的位置
+				if inputBuffer.name != "" {
+					fmt.Printf("%s%s failed.\n", inputBuffer.location(), strings.Fields(string(inputBuffer.buffer))[0])
+				}
+				ok = false
 			}
-
+		} else {
+			measure := shell.startMeasure()
+			ok = shell.executeStatement(inputBuffer)
+			measure()
 		}
 
-		var statement Statement
-		switch prepareStatement(inputBuffer, &statement) {
-		case PREPARE_SUCCESS:
-			break
-		case PREPARE_NEGATIVE_ID:
-			fmt.Printf("ID must be positive.\n")
-			continue
-		case PREPARE_STRING_TOO_LONG:
-			fmt.Printf("String is too long.\n")
-			continue
-		case PREPARE_SYNTAX_ERROR:
-			fmt.Printf("Syntax error. could not parse statement.\n")
-			continue
-		case PREPARE_UNRECOGNIZED_STATEMENT:
-			fmt.Printf("Unrecognized keyword at start of '%s'.\n", inputBuffer.buffer)
-			continue
+		if !ok && shell.bail {
+			return false
 		}
+	}
+}
 
-		switch executeStatement(&statement, table) {
-		case EXECUTE_SUCCESS:
-			fmt.Printf("Executed.\n")
-			break
-		case EXECUTE_DUPLICATE_KEY:
-			fmt.Printf("Error: Duplicate key.\n")
-			break
-		case EXECUTE_TABLE_FULL:
-			fmt.Printf("Error: Table full.\n")
-			break
+func (shell *Shell) executeStatement(inputBuffer *InputBuffer) bool {
+	settings := shell.settings
+	rows, err := shell.db.Query(string(inputBuffer.buffer))
+	if err == nil {
+		err = printRows(settings.out(), settings, rows)
+		rows.Close()
+	}
+	// .once 只作用于下一条语句, 语句出错时也恢复标准输出
+	if err := settings.finishStatement(); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	}
+	if err != nil {
+		fmt.Printf("%s", inputBuffer.location())
+		printError(err, inputBuffer)
+		return false
+	}
+	if !shell.batch {
+		fmt.Printf("Executed.\n")
+	}
+	return true
+}
+
+// startMeasure 开启 .timer 或 .profile 时记录开始的时间和计数,
+// 返回的函数在语句执行完后输出差值
+func (shell *Shell) startMeasure() func() {
+	if !shell.timer && !shell.profile {
+		return func() {}
+	}
+	start := time.Now()
+	startUser, startSys := cpuTime()
+	startCounters, err := shell.db.Counters()
+
+	return func() {
+		if shell.timer {
+			user, sys := cpuTime()
+			fmt.Printf("Run Time: real %.6f user %.6f sys %.6f\n",
+				time.Since(start).Seconds(), (user - startUser).Seconds(), (sys - startSys).Seconds())
+		}
+		if shell.profile && err == nil {
+			counters, err := shell.db.Counters()
+			if err != nil {
+				return
+			}
+			counters = counters.Sub(startCounters)
+			fmt.Printf("Profile: pages read %d (disk %d, cache %d), pages written %d, splits %d\n",
+				counters.PagesRead+counters.CacheHits, counters.PagesRead, counters.CacheHits,
+				counters.PagesWritten, counters.Splits)
 		}
+	}
+}
 
+// exit 关闭输出文件和数据库后退出, 没有提交的事务被回滚
+func (shell *Shell) exit(status int) {
+	if err := shell.settings.closeOutput(); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	}
+	if err := shell.db.Close(); err != nil {
+		fmt.Printf("Error closing db file. %s\n", err.Error())
+		os.Exit(EXIT_FAILURE)
 	}
+	os.Exit(status)
 }
 
 const (
@@ -70,15 +186,72 @@ const (
 	EXIT_SUCCESS = 0
 )
 
-func printPrompt() {
-	fmt.Printf("db > ")
+// promptText 语句没有以;结束时显示继续输入的提示
+func promptText(continuation bool) string {
+	if continuation {
+		return "   ...> "
+	}
+	return "db > "
+}
+
+func printError(err error, inputBuffer *InputBuffer) {
+	var prepareResult minisqlite.PrepareResult
+	var executeResult minisqlite.ExecuteResult
+
+	if errors.As(err, &prepareResult) {
+		switch prepareResult {
+		case minisqlite.PREPARE_NEGATIVE_ID:
+			fmt.Printf("ID must be positive.\n")
+		case minisqlite.PREPARE_STRING_TOO_LONG:
+			fmt.Printf("String is too long.\n")
+		case minisqlite.PREPARE_SYNTAX_ERROR:
+			fmt.Printf("Syntax error. could not parse statement.\n")
+		case minisqlite.PREPARE_UNKNOWN_COLUMN:
+			fmt.Printf("Error: no such column.\n")
+		case minisqlite.PREPARE_AMBIGUOUS_COLUMN:
+			fmt.Printf("Error: ambiguous column name.\n")
+		case minisqlite.PREPARE_UNRECOGNIZED_STATEMENT:
+			fmt.Printf("Unrecognized keyword at start of '%s'.\n", inputBuffer.buffer)
+		}
+		return
+	}
+
+	if errors.As(err, &executeResult) {
+		switch executeResult {
+		case minisqlite.EXECUTE_DUPLICATE_KEY:
+			fmt.Printf("Error: Duplicate key.\n")
+		case minisqlite.EXECUTE_TABLE_FULL:
+			fmt.Printf("Error: Table full.\n")
+		case minisqlite.EXECUTE_UNKNOWN_TABLE:
+			fmt.Printf("Error: no such table.\n")
+		case minisqlite.EXECUTE_TABLE_EXISTS:
+			fmt.Printf("Error: table already exists.\n")
+		case minisqlite.EXECUTE_READONLY_TABLE:
+			fmt.Printf("Error: table may not be modified.\n")
+		case minisqlite.EXECUTE_READONLY_DATABASE:
+			fmt.Printf("Error: attempt to write a readonly database.\n")
+		case minisqlite.EXECUTE_NEGATIVE_ID:
+			fmt.Printf("ID must be positive.\n")
+		case minisqlite.EXECUTE_STRING_TOO_LONG:
+			fmt.Printf("String is too long.\n")
+		case minisqlite.EXECUTE_TYPE_MISMATCH:
+			fmt.Printf("Error: datatype mismatch.\n")
+		case minisqlite.EXECUTE_TRANSACTION_ACTIVE:
+			fmt.Printf("Error: cannot start a transaction within a transaction.\n")
+		case minisqlite.EXECUTE_NO_TRANSACTION:
+			fmt.Printf("Error: no transaction is active.\n")
+		}
+		return
+	}
+
+	fmt.Printf("Error: %s\n", err.Error())
 }
 
 func printConstants() {
-	fmt.Printf("ROW_SIZE: %d\n", ROW_SIZE)
-	fmt.Printf("COMMON_NODE_HEADER_SIZE: %d\n", COMMON_NODE_HEADER_SIZE)
-	fmt.Printf("LEAF_NODE_HEADER_SIZE: %d\n", LEAF_NODE_HEADER_SIZE)
-	fmt.Printf("LEAF_NODE_CELL_SIZE: %d\n", LEAF_NODE_CELL_SIZE)
-	fmt.Printf("LEAF_NODE_SPACE_FOR_CELLS: %d\n", LEAF_NODE_SPACE_FOR_CELLS)
-	fmt.Printf("LEAF_NODE_MAX_CELLS: %d\n", LEAF_NODE_MAX_CELLS)
+	fmt.Printf("ROW_SIZE: %d\n", minisqlite.ROW_SIZE)
+	fmt.Printf("COMMON_NODE_HEADER_SIZE: %d\n", minisqlite.COMMON_NODE_HEADER_SIZE)
+	fmt.Printf("LEAF_NODE_HEADER_SIZE: %d\n", minisqlite.LEAF_NODE_HEADER_SIZE)
+	fmt.Printf("LEAF_NODE_CELL_SIZE: %d\n", minisqlite.LEAF_NODE_CELL_SIZE)
+	fmt.Printf("LEAF_NODE_SPACE_FOR_CELLS: %d\n", minisqlite.LEAF_NODE_SPACE_FOR_CELLS)
+	fmt.Printf("LEAF_NODE_MAX_CELLS: %d\n", minisqlite.LEAF_NODE_MAX_CELLS)
 }
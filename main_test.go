@@ -0,0 +1,766 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"sqlite/minisqlite"
+)
+
+// openDB 在临时目录中打开数据库, 测试结束时关闭
+func openDB(t *testing.T) *minisqlite.DB {
+	t.Helper()
+
+	db, err := minisqlite.Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// captureStdout 返回fn执行期间写到标准输出的内容
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		output <- string(b)
+	}()
+
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		r.Close()
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+// queryOutput 按settings格式化查询结果
+func queryOutput(t *testing.T, db *minisqlite.DB, settings *OutputSettings, query string) string {
+	t.Helper()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var output strings.Builder
+	if err := printRows(&output, settings, rows); err != nil {
+		t.Fatal(err)
+	}
+	return output.String()
+}
+
+// openShell 像 -batch 一样执行输入的命令行
+func openShell(t *testing.T) *Shell {
+	t.Helper()
+
+	return &Shell{db: openDB(t), settings: newOutputSettings(), batch: true}
+}
+
+// runInput 像管道输入一样执行input, 返回输出和run的结果
+func runInput(t *testing.T, shell *Shell, input string) (string, bool) {
+	t.Helper()
+
+	inputBuffer := newInputBuffer(strings.NewReader(input))
+	inputBuffer.batch = shell.batch
+	var ok bool
+	output := captureStdout(t, func() { ok = shell.run(inputBuffer) })
+	return output, ok
+}
+
+func TestOutputModes(t *testing.T) {
+	db := openDB(t)
+	if _, err := db.Exec("insert 1 ? ?", "a|b", `x,"y"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert 2 ? ?", "<b>&", "two\nlines"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode     OutputMode
+		headers  bool
+		expected string
+	}{
+		{MODE_TUPLE, false, "(1, a|b, x,\"y\", NULL, 0.5)\n(2, <b>&, two\nlines, NULL, 1.0)\n"},
+		{MODE_TUPLE, true, "(id, username, email, null, id * 0.5)\n(1, a|b, x,\"y\", NULL, 0.5)\n(2, <b>&, two\nlines, NULL, 1.0)\n"},
+		{MODE_CSV, true, "id,username,email,null,id * 0.5\n1,a|b,\"x,\"\"y\"\"\",NULL,0.5\n2,<b>&,\"two\nlines\",NULL,1.0\n"},
+		{MODE_TAB, false, "1\ta|b\tx,\"y\"\tNULL\t0.5\n2\t<b>&\ttwo\nlines\tNULL\t1.0\n"},
+		{MODE_JSON, false, "[{\"id\":1,\"username\":\"a|b\",\"email\":\"x,\\\"y\\\"\",\"null\":null,\"id * 0.5\":0.5},\n" +
+			"{\"id\":2,\"username\":\"<b>&\",\"email\":\"two\\nlines\",\"null\":null,\"id * 0.5\":1.0}]\n"},
+		{MODE_JSONLINES, false, "{\"id\":1,\"username\":\"a|b\",\"email\":\"x,\\\"y\\\"\",\"null\":null,\"id * 0.5\":0.5}\n" +
+			"{\"id\":2,\"username\":\"<b>&\",\"email\":\"two\\nlines\",\"null\":null,\"id * 0.5\":1.0}\n"},
+		{MODE_LINE, false, "      id = 1\nusername = a|b\n   email = x,\"y\"\n    null = NULL\nid * 0.5 = 0.5\n\n" +
+			"      id = 2\nusername = <b>&\n   email = two\nlines\n    null = NULL\nid * 0.5 = 1.0\n"},
+	}
+	for _, test := range tests {
+		settings := newOutputSettings()
+		settings.mode = test.mode
+		settings.headers = test.headers
+		output := queryOutput(t, db, settings, "select id, username, email, null, id * 0.5 from users")
+		if output != test.expected {
+			t.Fatalf("mode %s headers %v: expected\n%s\ngot\n%s", test.mode, test.headers, test.expected, output)
+		}
+	}
+}
+
+func TestOutputBoxAndMarkdown(t *testing.T) {
+	db := openDB(t)
+	if _, err := db.Exec("insert 1 a|b héllo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert 22 c x"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按字符计算宽度, markdown中的|需要转义
+	tests := []struct {
+		mode     OutputMode
+		expected string
+	}{
+		{MODE_TABLE, "┌────┬──────────┬───────┐\n" +
+			"│ id │ username │ email │\n" +
+			"├────┼──────────┼───────┤\n" +
+			"│ 1  │ a|b      │ héllo │\n" +
+			"│ 22 │ c        │ x     │\n" +
+			"└────┴──────────┴───────┘\n"},
+		{MODE_MARKDOWN, "| id | username | email |\n" +
+			"|----|----------|-------|\n" +
+			"| 1  | a\\|b     | héllo |\n" +
+			"| 22 | c        | x     |\n"},
+	}
+	for _, test := range tests {
+		settings := newOutputSettings()
+		settings.mode = test.mode
+		if output := queryOutput(t, db, settings, "select * from users"); output != test.expected {
+			t.Fatalf("mode %s: expected\n%s\ngot\n%s", test.mode, test.expected, output)
+		}
+	}
+
+	// 没有结果行时什么也不输出
+	if output := queryOutput(t, db, newOutputSettings(), "select * from users where id > 100"); output != "" {
+		t.Fatalf("expected no output, got %q", output)
+	}
+}
+
+func TestOutputSettings(t *testing.T) {
+	db := openDB(t)
+	if _, err := db.Exec("insert 1 a b"); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := newOutputSettings()
+	tests := []struct {
+		args     []string
+		expected string
+		mode     OutputMode
+	}{
+		{nil, "current output mode: tuple\n", MODE_TUPLE},
+		{[]string{"csv"}, "", MODE_CSV},
+		{[]string{"nope"}, "Usage: .mode tuple|csv|tab|json|jsonlines|table|line|markdown\n", MODE_CSV},
+		{[]string{"tab", "x"}, "Usage: .mode tuple|csv|tab|json|jsonlines|table|line|markdown\n", MODE_CSV},
+		{[]string{"markdown"}, "", MODE_MARKDOWN},
+	}
+	for _, test := range tests {
+		output := captureStdout(t, func() { doMode(test.args, settings) })
+		if output != test.expected || settings.mode != test.mode {
+			t.Fatalf("%q: expected %q %s, got %q %s", test.args, test.expected, test.mode, output, settings.mode)
+		}
+	}
+
+	// .nullvalue 的参数可以用引号指定空字符串或带空格的值, json中总是null
+	for _, arg := range []string{"''", "\"(no value)\"", "'a\"", "x"} {
+		settings = newOutputSettings()
+		settings.mode = MODE_TAB
+		settings.headers = true
+		settings.nullValue = unquote(arg)
+		expected := "id\tnull\n1\t" + settings.nullValue + "\n"
+		if output := queryOutput(t, db, settings, "select id, null from users"); output != expected {
+			t.Fatalf("nullvalue %s: expected %q, got %q", arg, expected, output)
+		}
+	}
+	if unquote("''") != "" || unquote("\"(no value)\"") != "(no value)" || unquote("'a\"") != "'a\"" {
+		t.Fatalf("unexpected unquote results")
+	}
+	settings.mode = MODE_JSON
+	if output := queryOutput(t, db, settings, "select null from users"); output != "[{\"null\":null}]\n" {
+		t.Fatalf("expected json null, got %q", output)
+	}
+}
+
+func TestOutputRedirect(t *testing.T) {
+	shell := openShell(t)
+	if _, err := shell.db.Exec("insert 1 a b"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	once := filepath.Join(dir, "once.txt")
+	all := filepath.Join(dir, "all.txt")
+
+	// .once 只作用于下一条语句, 出错的语句也会恢复标准输出
+	input := ".once " + once + "\n" +
+		"select id from users;\n" +
+		"select username from users;\n" +
+		".once " + once + "\n" +
+		"select nope from users;\n" +
+		"select email from users;\n" +
+		".output " + all + "\n" +
+		"select id from users;\n" +
+		".dump\n" +
+		"select username from users;\n" +
+		".output stdout\n" +
+		"select email from users;\n"
+	output, _ := runInput(t, shell, input)
+	if output != "(a)\nError: no such column.\n(b)\n(b)\n" {
+		t.Fatalf("unexpected stdout %q", output)
+	}
+
+	// 第二个.once重新创建了文件, 出错的语句没有输出
+	b, err := os.ReadFile(once)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "" {
+		t.Fatalf("unexpected .once output %q", b)
+	}
+	b, err = os.ReadFile(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "(1)\nbegin transaction;\ninsert into users values (1, 'a', 'b');\ncommit;\n(a)\n" {
+		t.Fatalf("unexpected .output content %q", b)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{".once\n", "Usage: .output [FILE|stdout] or .once FILE\n"},
+		{".output a b\n", "Usage: .output [FILE|stdout] or .once FILE\n"},
+		{".output " + filepath.Join(dir, "missing", "x.txt") + "\n", "Error: open " + filepath.Join(dir, "missing", "x.txt") + ": no such file or directory\n"},
+	}
+	for _, test := range tests {
+		if output, _ := runInput(t, shell, test.input); output != test.expected {
+			t.Fatalf("%q: expected %q, got %q", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestPageAndTreeRedirect(t *testing.T) {
+	shell := openShell(t)
+	if _, err := shell.db.Exec("insert 1 a b"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "page.txt")
+
+	// .page 和 .btree 与查询结果一样写到 .once 的文件
+	output, _ := runInput(t, shell, ".once "+path+"\n.page 0\n.btree\n")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "page 0\n  type:        leaf\n") {
+		t.Fatalf("unexpected .page output %q", b)
+	}
+	if !strings.HasPrefix(output, "Tree:\n") {
+		t.Fatalf("expected .btree on stdout after .once, got %q", output)
+	}
+
+	output, _ = runInput(t, shell, ".once "+path+"\n.btree\n.page 0\n")
+	if b, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "Tree:\n") || !strings.HasPrefix(output, "page 0\n  type:        leaf\n") {
+		t.Fatalf("unexpected .btree output %q and stdout %q", b, output)
+	}
+}
+
+func TestStatsRedirect(t *testing.T) {
+	shell := openShell(t)
+	if _, err := shell.db.Exec("insert 1 a b"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "stats.txt")
+
+	// .stats 写到 .once 的文件, 之后恢复标准输出
+	output, _ := runInput(t, shell, ".once "+path+"\n.stats\n.stats\n")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "Total pages: 1\nLeaf pages: 1\n") || !strings.Contains(string(b), "Bytes written: ") {
+		t.Fatalf("unexpected .stats output %q", b)
+	}
+	if !strings.HasPrefix(output, "Total pages: 1\n") || strings.Count(output, "Total pages") != 1 {
+		t.Fatalf("expected one .stats on stdout after .once, got %q", output)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	shell := openShell(t)
+	if _, err := shell.db.Exec("insert 1 a b"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "check.txt")
+
+	// .check 的结果写到 .output 的文件, .output stdout 之后恢复
+	output, ok := runInput(t, shell, ".output "+path+"\n.check\n.output stdout\n.check\n")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(b) != "ok\n" || output != "ok\n" {
+		t.Fatalf("unexpected .check output %q and stdout %q", b, output)
+	}
+}
+
+func TestTimerAndProfile(t *testing.T) {
+	shell := openShell(t)
+
+	// 每条语句之后输出用时和页面计数, 元命令和关闭之后的语句不输出
+	output, ok := runInput(t, shell, ".timer on\n.profile on\ninsert 1 a b;\nselect;\n.timer off\n.profile off\nselect;\n")
+	if !ok {
+		t.Fatalf("unexpected failure:\n%s", output)
+	}
+	runTime := regexp.MustCompile(`(?m)^Run Time: real \d+\.\d{6} user \d+\.\d{6} sys \d+\.\d{6}$`)
+	if n := len(runTime.FindAllString(output, -1)); n != 2 {
+		t.Fatalf("expected 2 Run Time lines, got %d:\n%s", n, output)
+	}
+	profile := regexp.MustCompile(`(?m)^Profile: pages read (\d+) \(disk (\d+), cache (\d+)\), pages written (\d+), splits (\d+)$`)
+	matches := profile.FindAllStringSubmatch(output, -1)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 Profile lines, got %d:\n%s", len(matches), output)
+	}
+	if insert := matches[0]; insert[4] == "0" || insert[5] != "0" {
+		t.Fatalf("expected the insert to write pages without splits: %s", insert[0])
+	}
+	if sel := matches[1]; sel[1] == "0" || sel[4] != "0" {
+		t.Fatalf("expected the select to read pages without writing: %s", sel[0])
+	}
+	if !strings.HasSuffix(output, "(1, a, b)\n") {
+		t.Fatalf("expected no measurement after .timer off and .profile off:\n%s", output)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		input      string
+		statements []string
+		rest       string
+	}{
+		{"select 1", nil, "select 1"},
+		{"select 1;", []string{"select 1"}, ""},
+		{"select 1; select 2;\n", []string{"select 1", " select 2"}, "\n"},
+		{"insert 1 'a;b' c; sel", []string{"insert 1 'a;b' c"}, " sel"},
+		{"select 'it''s;';", []string{"select 'it''s;'"}, ""},
+		{"select 'open;\nstill open;", nil, "select 'open;\nstill open;"},
+		{";;", []string{"", ""}, ""},
+	}
+	for _, test := range tests {
+		statements, rest := splitStatements(test.input)
+		if strings.Join(statements, "|") != strings.Join(test.statements, "|") || len(statements) != len(test.statements) || rest != test.rest {
+			t.Fatalf("%q: expected %q %q, got %q %q", test.input, test.statements, test.rest, statements, rest)
+		}
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	type statement struct {
+		text string
+		line int
+	}
+	tests := []struct {
+		input    string
+		expected []statement
+		prompts  string
+	}{
+		{"select 1;\n", []statement{{"select 1", 1}}, "db > db > "},
+		{"select 1; select 2;\n", []statement{{"select 1", 1}, {"select 2", 1}}, "db > db > "},
+		{"select\n  id\nfrom users\n;\n", []statement{{"select\n  id\nfrom users", 1}}, "db >    ...>    ...>    ...> db > "},
+		{"\n\nselect 1;\r\n", []statement{{"select 1", 3}}, "db > db > db > db > "},
+		{"select 1; select\n2;\nselect 3;\n", []statement{{"select 1", 1}, {"select\n2", 1}, {"select 3", 3}}, "db >    ...> db > db > "},
+		{"insert 1 'a;\nb' c;\n", []statement{{"insert 1 'a;\nb' c", 1}}, "db >    ...> db > "},
+		{"  .mode csv  \nselect 1;\n", []statement{{".mode csv", 1}, {"select 1", 2}}, "db > db > db > "},
+		// 语句中间以.开头的行不是元命令
+		{"select id\n.5;\n", []statement{{"select id\n.5", 1}}, "db >    ...> db > "},
+		{"; ;\n\nselect 1;\n", []statement{{"select 1", 3}}, "db > db > db > db > "},
+		// 输入结束时最后没有;的语句也会执行
+		{"select 1;\nselect 2", []statement{{"select 1", 1}, {"select 2", 2}}, "db > db >    ...> db > "},
+		{"select 1;\nselect\n2\n", []statement{{"select 1", 1}, {"select\n2", 2}}, "db > db >    ...>    ...> db > "},
+		{"", nil, "db > "},
+	}
+	for _, test := range tests {
+		inputBuffer := newInputBuffer(strings.NewReader(test.input))
+		var statements []statement
+		var err error
+		prompts := captureStdout(t, func() {
+			for err = inputBuffer.readInput(); err == nil; err = inputBuffer.readInput() {
+				statements = append(statements, statement{string(inputBuffer.buffer), inputBuffer.line})
+			}
+		})
+		if err != io.EOF {
+			t.Fatalf("%q: expected io.EOF, got %v", test.input, err)
+		}
+		if fmt.Sprint(statements) != fmt.Sprint(test.expected) || len(statements) != len(test.expected) || prompts != test.prompts {
+			t.Fatalf("%q: expected %q %q, got %q %q", test.input, test.expected, test.prompts, statements, prompts)
+		}
+	}
+
+	// -batch 和 -c -f 不显示提示符
+	inputBuffer := newInputBuffer(strings.NewReader("select\n1;\n"))
+	inputBuffer.batch = true
+	if prompts := captureStdout(t, func() { inputBuffer.readInput() }); prompts != "" {
+		t.Fatalf("expected no prompts in batch mode, got %q", prompts)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	db := openDB(t)
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+	completer := newCompleter(db)
+
+	tests := []struct {
+		line       string
+		start      int
+		candidates []string
+	}{
+		{".he", 0, []string{".headers"}},
+		{".o", 0, []string{".once", ".output"}},
+		{".mode j", 6, []string{"json", "jsonlines"}},
+		{".mode ", 6, []string{"csv", "json", "jsonlines", "line", "markdown", "tab", "table", "tuple"}},
+		{".read ma", 6, nil},
+		{"SEL", 0, []string{"select"}},
+		{"select * from ord", 14, []string{"orders"}},
+		{"select user", 7, []string{"username", "users"}},
+		{"select ", 7, nil},
+	}
+	for _, test := range tests {
+		line := []rune(test.line)
+		start, candidates := completer(line, len(line))
+		if start != test.start || strings.Join(candidates, ",") != strings.Join(test.candidates, ",") {
+			t.Fatalf("%q: expected %d %v, got %d %v", test.line, test.start, test.candidates, start, candidates)
+		}
+	}
+
+	// 光标在行中间时只看光标前的词
+	if start, candidates := completer([]rune("select em, id"), 9); start != 7 || strings.Join(candidates, ",") != "email" {
+		t.Fatalf("expected email at 7, got %d %v", start, candidates)
+	}
+}
+
+func TestLineEditorComplete(t *testing.T) {
+	words := []string{"select", "selected", "sum"}
+	completer := func(line []rune, pos int) (int, []string) {
+		start := pos
+		for start > 0 && isWordRune(line[start-1]) {
+			start--
+		}
+		return start, matchPrefix(words, string(line[start:pos]))
+	}
+
+	// 一个候选词时补全并加空格, 多个时补全到共同前缀, 不能再补全时列出候选词, 没有候选词时响铃
+	tests := []struct {
+		line     string
+		pos      int
+		expected string
+		pos2     int
+		output   string
+	}{
+		{"su", 2, "sum ", 4, ""},
+		{"se", 2, "select", 6, ""},
+		{"select", 6, "select", 6, "\r\nselect  selected\r\n"},
+		{"x", 1, "x", 1, "\a"},
+		{"se from", 2, "select from", 6, ""},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		editor := &LineEditor{out: &out, completer: completer}
+		editor.line, editor.pos = []rune(test.line), test.pos
+		editor.complete()
+		if string(editor.line) != test.expected || editor.pos != test.pos2 || out.String() != test.output {
+			t.Fatalf("%q: expected %q %d %q, got %q %d %q",
+				test.line, test.expected, test.pos2, test.output, string(editor.line), editor.pos, out.String())
+		}
+	}
+
+	if prefix := commonPrefix([]string{"jsonlines", "json", "jsonx"}); prefix != "json" {
+		t.Fatalf("expected common prefix json, got %q", prefix)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	editor := &LineEditor{historyFile: path}
+	for _, line := range []string{"select 1;", "select 1;", "  ", "", ".mode csv", "select 1;"} {
+		editor.addHistory(line)
+	}
+	// 空行和与上一行相同的行不加入历史
+	expected := "select 1;\n.mode csv\nselect 1;\n"
+	if strings.Join(editor.history, "\n")+"\n" != expected {
+		t.Fatalf("unexpected history %q", editor.history)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("unexpected history file %q", b)
+	}
+
+	editor = &LineEditor{historyFile: path}
+	editor.loadHistory()
+	if strings.Join(editor.history, "\n")+"\n" != expected {
+		t.Fatalf("unexpected loaded history %q", editor.history)
+	}
+
+	// 超过HISTORY_MAX_LINES行时只保留最后的部分并重写文件
+	var lines strings.Builder
+	for i := 0; i < HISTORY_MAX_LINES+5; i++ {
+		fmt.Fprintf(&lines, "select %d;\n", i)
+	}
+	if err := os.WriteFile(path, []byte(lines.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	editor = &LineEditor{historyFile: path}
+	editor.loadHistory()
+	if len(editor.history) != HISTORY_MAX_LINES || editor.history[0] != "select 5;" {
+		t.Fatalf("expected %d lines starting at select 5, got %d starting at %q", HISTORY_MAX_LINES, len(editor.history), editor.history[0])
+	}
+	if b, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "\n") != HISTORY_MAX_LINES || !strings.HasPrefix(string(b), "select 5;\n") {
+		t.Fatalf("history file was not trimmed")
+	}
+
+	// 没有历史文件时只保存在内存中
+	editor = &LineEditor{}
+	editor.loadHistory()
+	editor.addHistory("select 1;")
+	if len(editor.history) != 1 {
+		t.Fatalf("unexpected history %q", editor.history)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	tests := []struct {
+		input string
+		keys  []rune
+	}{
+		{"aé\r", []rune{'a', 'é', KEY_ENTER}},
+		{"\x1b[A\x1b[B\x1b[C\x1b[D", []rune{KEY_UP, KEY_DOWN, KEY_RIGHT, KEY_LEFT}},
+		{"\x1bOH\x1bOF\x1b[H\x1b[F", []rune{KEY_HOME, KEY_END, KEY_HOME, KEY_END}},
+		{"\x1b[1~\x1b[7~\x1b[4~\x1b[8~\x1b[3~", []rune{KEY_HOME, KEY_HOME, KEY_END, KEY_END, KEY_DELETE}},
+		{"\x1b[15~x\x1b[Zx\x1bxy", []rune{KEY_UNKNOWN, 'x', KEY_UNKNOWN, 'x', KEY_UNKNOWN, 'y'}},
+	}
+	for _, test := range tests {
+		editor := &LineEditor{reader: bufio.NewReader(strings.NewReader(test.input))}
+		var keys []rune
+		for {
+			key, err := editor.readKey()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(test.keys) {
+			t.Fatalf("%q: expected %v, got %v", test.input, test.keys, keys)
+		}
+	}
+}
+
+func TestReverseSearch(t *testing.T) {
+	history := []string{"select a;", "insert 1 x y", "select b;"}
+	tests := []struct {
+		input string
+		key   rune
+		line  string
+	}{
+		// 从最新的一行开始找, 再按Ctrl-R找更早的一行
+		{"sel\r", KEY_ENTER, "select b;"},
+		{"sel\x12\x05", KEY_CTRL_E, "select a;"},
+		{"ins\x1b[D", KEY_LEFT, "insert 1 x y"},
+		// 找不到时保留上一次找到的行, 删除字符后重新从最新的一行找
+		{"selz\r", KEY_ENTER, "select b;"},
+		{"x\x7fsel\r", KEY_ENTER, "select b;"},
+		// Ctrl-G 恢复原来的行
+		{"ins\x07", 0, "typed"},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		editor := &LineEditor{reader: bufio.NewReader(strings.NewReader(test.input)), out: &out, history: history}
+		editor.setLine([]rune("typed"))
+		key, err := editor.reverseSearch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != test.key || string(editor.line) != test.line {
+			t.Fatalf("%q: expected %d %q, got %d %q", test.input, test.key, test.line, key, string(editor.line))
+		}
+	}
+}
+
+// TestMain 设置了MINISQLITE_TEST_MAIN时测试程序作为命令行运行, 用于检查参数和退出状态
+func TestMain(m *testing.M) {
+	if os.Getenv("MINISQLITE_TEST_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// runMain 以args运行命令行, 返回输出和退出状态
+func runMain(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "MINISQLITE_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(output), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), 0
+}
+
+func TestFlags(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	script := filepath.Join(dir, "script.sql")
+	if err := os.WriteFile(script, []byte("select id\n  from users;\nselect nope\n  from users;\nselect username from users;\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.sql")
+
+	tests := []struct {
+		args     []string
+		stdin    string
+		expected string
+		status   int
+	}{
+		{nil, "", "Must supply a database filename.\n", EXIT_FAILURE},
+		{[]string{"-c", "insert 1 a x; select * from users;", path}, "", "(1, a, x)\n", EXIT_SUCCESS},
+		// 没有-bail时出错后继续执行, 退出状态为成功
+		{[]string{"-c", "select nope from users; select id from users;", path}, "", "Error: no such column.\n(1)\n", EXIT_SUCCESS},
+		{[]string{"-bail", "-c", "select nope from users; select id from users;", path}, "", "Error: no such column.\n", EXIT_FAILURE},
+		{[]string{"-bail", "-c", ".nope\nselect id from users;", path}, "", "Unrecognized command '.nope'.\n", EXIT_FAILURE},
+		{[]string{"-f", missing, path}, "", "Error: cannot open \"" + missing + "\"\n", EXIT_FAILURE},
+		// -f 的错误带有文件名和语句开始的行号
+		{[]string{"-f", script, path}, "", "(1)\n" + script + ":3: Error: no such column.\n(a)\n", EXIT_SUCCESS},
+		{[]string{"-bail", "-f", script, path}, "", "(1)\n" + script + ":3: Error: no such column.\n", EXIT_FAILURE},
+		// 标准输入不是终端时按行读取, -batch 不显示提示符和Executed.
+		{[]string{"-batch", path}, "insert 2 b y;\nselect id from users;\n", "(1)\n(2)\n", EXIT_SUCCESS},
+		{[]string{path}, "select id\nfrom users where id = 1;\n", "db >    ...> (1)\nExecuted.\ndb > ", EXIT_SUCCESS},
+		{[]string{"-batch", "-bail", path}, "select nope from users;\n", "Error: no such column.\n", EXIT_FAILURE},
+		{[]string{"-c", ".exit\nselect id from users;", path}, "", "", EXIT_SUCCESS},
+	}
+	for _, test := range tests {
+		output, status := runMain(t, test.stdin, test.args...)
+		if output != test.expected || status != test.status {
+			t.Fatalf("%q: expected %d %q, got %d %q", test.args, test.status, test.expected, status, output)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.sql":      "insert 1 a x;\n.read sub/inner.sql\nselect id from users;\n",
+		"sub/inner.sql": "select username\n  from users;\n.read leaf.sql\n",
+		"sub/leaf.sql":  "\nselect nope from users;\n.nope\n.read missing.sql\nselect email from users;\n",
+		"self.sql":      ".read self.sql\n",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaf := filepath.Join(dir, "sub", "leaf.sql")
+
+	// 嵌套的 .read 相对于所在文件的目录, 错误带有文件名和行号
+	shell := openShell(t)
+	output, ok := runInput(t, shell, ".read "+filepath.Join(dir, "main.sql")+"\n")
+	expected := "(a)\n" +
+		leaf + ":2: Error: no such column.\n" +
+		leaf + ":3: Unrecognized command '.nope'.\n" +
+		"Error: cannot open \"" + filepath.Join(dir, "sub", "missing.sql") + "\"\n" +
+		leaf + ":4: .read failed.\n" +
+		"(x)\n" +
+		"(1)\n"
+	if !ok || output != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, output)
+	}
+
+	// -bail 在第一个错误处停止, 每一层 .read 都失败
+	shell = openShell(t)
+	output, ok = runInput(t, shell, ".read -bail "+filepath.Join(dir, "main.sql")+"\nselect id from users;\n")
+	expected = "(a)\n" +
+		leaf + ":2: Error: no such column.\n" +
+		filepath.Join(dir, "sub", "inner.sql") + ":3: .read failed.\n" +
+		filepath.Join(dir, "main.sql") + ":2: .read failed.\n" +
+		"(1)\n"
+	if !ok || output != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, output)
+	}
+	if shell.bail || shell.readDepth != 0 {
+		t.Fatalf("expected bail and depth restored, got %v %d", shell.bail, shell.readDepth)
+	}
+
+	// 读自己的文件在MAX_READ_DEPTH层停止
+	output, _ = runInput(t, shell, ".read "+filepath.Join(dir, "self.sql")+"\n")
+	expected = "Error: .read nested too deeply\n" + filepath.Join(dir, "self.sql") + ":1: .read failed.\n"
+	if output != expected {
+		t.Fatalf("expected %q, got %q", expected, output)
+	}
+
+	// 交互输入中的相对路径相对于当前目录
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	output, _ = runInput(t, shell, ".read inner.sql\n.read\n")
+	expected = "(a)\n" +
+		"leaf.sql:2: Error: no such column.\n" +
+		"leaf.sql:3: Unrecognized command '.nope'.\n" +
+		"Error: cannot open \"missing.sql\"\n" +
+		"leaf.sql:4: .read failed.\n" +
+		"(x)\n" +
+		"Usage: .read [-bail] FILE\n"
+	if output != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, output)
+	}
+}
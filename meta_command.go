@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sqlite/minisqlite"
+)
+
+// MetaCommandResult 命令执行结果
+type MetaCommandResult int
+
+const (
+	META_COMMAND_SUCCESS MetaCommandResult = iota
+	META_COMMAND_UNRECOGNIZED_COMMAND
+	// META_COMMAND_FAILURE 命令出错, 错误已经输出
+	META_COMMAND_FAILURE
+)
+
+func doMetaCommand(inputBuffer *InputBuffer, shell *Shell) MetaCommandResult {
+	db, settings := shell.db, shell.settings
+
+	if string(inputBuffer.buffer) == ".exit" {
+		inputBuffer.closeInputBuffer()
+		shell.exit(EXIT_SUCCESS)
+	} else if string(inputBuffer.buffer) == ".constants" {
+		fmt.Printf("Constants:\n")
+		printConstants()
+		return META_COMMAND_SUCCESS
+	} else if string(inputBuffer.buffer) == ".btree" {
+		return writeOutput(settings, func(w io.Writer) error {
+			fmt.Fprintf(w, "Tree:\n")
+			return db.PrintTree(w)
+		})
+	} else if string(inputBuffer.buffer) == ".btree dot" {
+		return writeOutput(settings, db.PrintTreeDot)
+	} else if string(inputBuffer.buffer) == ".btree json" {
+		return writeOutput(settings, db.PrintTreeJSON)
+	} else if string(inputBuffer.buffer) == ".dump" {
+		return writeOutput(settings, db.Dump)
+	} else if args := strings.Fields(string(inputBuffer.buffer)); args[0] == ".import" {
+		return doImport(args[1:], inputBuffer, db)
+	} else if args[0] == ".mode" {
+		return doMode(args[1:], settings)
+	} else if args[0] == ".headers" {
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			fmt.Printf("Usage: .headers on|off\n")
+			return META_COMMAND_FAILURE
+		}
+		settings.headers = args[1] == "on"
+		return META_COMMAND_SUCCESS
+	} else if args[0] == ".output" || args[0] == ".once" {
+		return doOutput(args, settings)
+	} else if args[0] == ".page" {
+		pageNum, err := strconv.ParseUint(strings.Join(args[1:], " "), 10, 32)
+		if len(args) != 2 || err != nil {
+			fmt.Printf("Usage: .page N\n")
+			return META_COMMAND_FAILURE
+		}
+		return writeOutput(settings, func(w io.Writer) error {
+			return db.PrintPage(w, uint32(pageNum))
+		})
+	} else if args[0] == ".nullvalue" {
+		settings.nullValue = unquote(strings.TrimSpace(strings.TrimPrefix(string(inputBuffer.buffer), ".nullvalue")))
+		return META_COMMAND_SUCCESS
+	} else if args[0] == ".read" {
+		return doRead(args[1:], inputBuffer, shell)
+	} else if args[0] == ".bail" || args[0] == ".timer" || args[0] == ".profile" {
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			fmt.Printf("Usage: %s on|off\n", args[0])
+			return META_COMMAND_FAILURE
+		}
+		switch args[0] {
+		case ".bail":
+			shell.bail = args[1] == "on"
+		case ".timer":
+			shell.timer = args[1] == "on"
+		case ".profile":
+			shell.profile = args[1] == "on"
+		}
+		return META_COMMAND_SUCCESS
+	} else if string(inputBuffer.buffer) == ".stats" {
+		return writeOutput(settings, func(w io.Writer) error {
+			return printStats(w, db)
+		})
+	} else if string(inputBuffer.buffer) == ".check" {
+		return doCheck(db, settings)
+	}
+	return META_COMMAND_UNRECOGNIZED_COMMAND
+}
+
+// metaCommandResult 输出err并返回META_COMMAND_FAILURE, err为nil时返回META_COMMAND_SUCCESS
+func metaCommandResult(err error) MetaCommandResult {
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return META_COMMAND_FAILURE
+	}
+	return META_COMMAND_SUCCESS
+}
+
+// writeOutput 把write的内容写到.output/.once指定的位置
+func writeOutput(settings *OutputSettings, write func(w io.Writer) error) MetaCommandResult {
+	err := write(settings.out())
+	if finishErr := settings.finishStatement(); err == nil {
+		err = finishErr
+	}
+	return metaCommandResult(err)
+}
+
+// doRead .read [-bail] FILE 逐条执行文件中的元命令和语句, 文件中可以继续 .read.
+// 脚本中的相对路径相对于脚本所在的目录, 交互输入时相对于当前目录.
+// -bail 或 .bail on 时在第一个错误处停止
+func doRead(args []string, current *InputBuffer, shell *Shell) MetaCommandResult {
+	bail := len(args) == 2 && args[0] == "-bail"
+	if bail {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Printf("Usage: .read [-bail] FILE\n")
+		return META_COMMAND_FAILURE
+	}
+	if shell.readDepth >= MAX_READ_DEPTH {
+		fmt.Printf("Error: .read nested too deeply\n")
+		return META_COMMAND_FAILURE
+	}
+
+	path := args[0]
+	if current.name != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(current.name), path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Error: cannot open \"%s\"\n", path)
+		return META_COMMAND_FAILURE
+	}
+	defer file.Close()
+
+	inputBuffer := newInputBuffer(file)
+	inputBuffer.batch = true
+	inputBuffer.name = path
+
+	shell.readDepth++
+	savedBail := shell.bail
+	shell.bail = shell.bail || bail
+	ok := shell.run(inputBuffer)
+	shell.bail = savedBail
+	shell.readDepth--
+	if !ok {
+		return META_COMMAND_FAILURE
+	}
+	return META_COMMAND_SUCCESS
+}
+
+// MAX_READ_DEPTH 防止文件 .read 自己时无限递归
+const MAX_READ_DEPTH = 16
+
+// doCheck .check 输出完整性检查发现的问题, 数据库完好时输出ok
+func doCheck(db *minisqlite.DB, settings *OutputSettings) MetaCommandResult {
+	var problems []string
+	result := writeOutput(settings, func(w io.Writer) error {
+		var err error
+		if problems, err = db.Check(); err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(w, "ok\n")
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(w, "%s\n", problem)
+		}
+		return nil
+	})
+	if result == META_COMMAND_SUCCESS && len(problems) > 0 {
+		return META_COMMAND_FAILURE
+	}
+	return result
+}
+
+// doImport .import FILE [TABLE]
+func doImport(args []string, inputBuffer *InputBuffer, db *minisqlite.DB) MetaCommandResult {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Printf("Usage: .import FILE [TABLE]\n")
+		return META_COMMAND_FAILURE
+	}
+	table := ""
+	if len(args) == 2 {
+		table = args[1]
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Error: cannot open \"%s\"\n", args[0])
+		return META_COMMAND_FAILURE
+	}
+	defer file.Close()
+
+	imported, rowErrors, err := db.Import(file, table)
+	if err != nil {
+		printError(err, inputBuffer)
+		return META_COMMAND_FAILURE
+	}
+	for _, rowError := range rowErrors {
+		fmt.Printf("%s:%d: %s\n", args[0], rowError.Line, rowError.Err.Error())
+	}
+	fmt.Printf("Imported %d rows, %d failed.\n", imported, len(rowErrors))
+	if len(rowErrors) > 0 {
+		return META_COMMAND_FAILURE
+	}
+	return META_COMMAND_SUCCESS
+}
+
+// doMode .mode 不带参数时显示当前格式
+func doMode(args []string, settings *OutputSettings) MetaCommandResult {
+	if len(args) == 0 {
+		fmt.Printf("current output mode: %s\n", settings.mode)
+		return META_COMMAND_SUCCESS
+	}
+	if len(args) == 1 {
+		for mode, name := range modeNames {
+			if args[0] == name {
+				settings.mode = OutputMode(mode)
+				return META_COMMAND_SUCCESS
+			}
+		}
+	}
+	fmt.Printf("Usage: .mode %s\n", strings.Join(modeNames, "|"))
+	return META_COMMAND_FAILURE
+}
+
+// unquote 去掉参数两边成对的引号, 用于指定空字符串或带空格的值
+func unquote(arg string) string {
+	if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// doOutput .output [FILE|stdout] 一直重定向, .once FILE 只重定向下一条语句
+func doOutput(args []string, settings *OutputSettings) MetaCommandResult {
+	if len(args) > 2 || (args[0] == ".once" && len(args) != 2) {
+		fmt.Printf("Usage: .output [FILE|stdout] or .once FILE\n")
+		return META_COMMAND_FAILURE
+	}
+
+	if len(args) == 1 || args[1] == "stdout" {
+		return metaCommandResult(settings.closeOutput())
+	}
+	return metaCommandResult(settings.redirect(args[1], args[0] == ".once"))
+}
+
+// printStats .stats 输出页面和缓存的统计
+func printStats(w io.Writer, db *minisqlite.DB) error {
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Total pages: %d\n", stats.TotalPages)
+	fmt.Fprintf(w, "Leaf pages: %d\n", stats.LeafPages)
+	fmt.Fprintf(w, "Internal pages: %d\n", stats.InternalPages)
+	fmt.Fprintf(w, "Free pages: %d\n", stats.FreePages)
+	fmt.Fprintf(w, "Tree height: %d\n", stats.TreeHeight)
+	fmt.Fprintf(w, "Average leaf fill: %.1f%% of %d cells\n", stats.LeafFillFactor*100, minisqlite.LEAF_NODE_MAX_CELLS)
+	fmt.Fprintf(w, "Page cache: %d/%d pages\n", stats.CachedPages, minisqlite.TABLE_MAX_PAGES)
+	fmt.Fprintf(w, "Cache hits: %d\n", stats.CacheHits)
+	fmt.Fprintf(w, "Cache misses: %d\n", stats.CacheMisses)
+	fmt.Fprintf(w, "Bytes read: %d\n", stats.BytesRead)
+	fmt.Fprintf(w, "Bytes written: %d\n", stats.BytesWritten)
+	return nil
+}
@@ -0,0 +1,325 @@
+package minisqlite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	aggregateSpillPartitions = 8
+	aggregateMaxSpillDepth   = 4
+)
+
+// 内存中最多保存的分组数, 超过后新的分组写入磁盘分区
+var aggregateMaxMemoryGroups = 4096
+
+type aggregateAccumulator struct {
+	count int64
+	sum   Value
+	value Value
+}
+
+func (acc *aggregateAccumulator) step(expr *exprNode, row []Value) {
+	if expr.star {
+		acc.count++
+		return
+	}
+
+	arg := evalExpr(expr.args[0], row, nil)
+	if arg.typ == valueNull {
+		return
+	}
+	acc.count++
+
+	switch expr.name {
+	case "sum", "avg":
+		arg = arg.numeric()
+		if acc.sum.typ == valueNull {
+			acc.sum = arg
+		} else {
+			acc.sum = evalArithmetic("+", acc.sum, arg)
+		}
+	case "min":
+		if acc.value.typ == valueNull || compareValues(arg, acc.value) < 0 {
+			acc.value = arg
+		}
+	case "max":
+		if acc.value.typ == valueNull || compareValues(arg, acc.value) > 0 {
+			acc.value = arg
+		}
+	}
+}
+
+func (acc *aggregateAccumulator) final(expr *exprNode) Value {
+	switch expr.name {
+	case "count":
+		return integerValue(acc.count)
+	case "sum":
+		return acc.sum
+	case "avg":
+		if acc.count == 0 {
+			return nullValue()
+		}
+		return realValue(acc.sum.float() / float64(acc.count))
+	}
+	return acc.value
+}
+
+type aggregateGroup struct {
+	// 分组中的第一行, 用于求值没有聚合的列
+	row          []Value
+	accumulators []aggregateAccumulator
+}
+
+type spillPartition struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// hashAggregator 按GROUP BY表达式对行做哈希聚合, 分组过多时把后来的分组分区写到临时文件
+type hashAggregator struct {
+	sel        *selectStatement
+	depth      int
+	groups     map[string]*aggregateGroup
+	order      []string
+	partitions []*spillPartition
+}
+
+func newHashAggregator(sel *selectStatement, depth int) *hashAggregator {
+	return &hashAggregator{
+		sel:    sel,
+		depth:  depth,
+		groups: make(map[string]*aggregateGroup),
+	}
+}
+
+func (aggregator *hashAggregator) add(row []Value) error {
+	// 1和1.0归入同一个分组, 文本按原样分组
+	var key []byte
+	for _, expr := range aggregator.sel.groupBy {
+		key = append(key, groupKey(evalExpr(expr, row, nil))...)
+	}
+
+	group, ok := aggregator.groups[string(key)]
+	if !ok {
+		if len(aggregator.groups) >= aggregateMaxMemoryGroups && aggregator.depth < aggregateMaxSpillDepth {
+			return aggregator.spill(key, row)
+		}
+		group = &aggregateGroup{
+			row:          append([]Value(nil), row...),
+			accumulators: make([]aggregateAccumulator, len(aggregator.sel.aggregates)),
+		}
+		aggregator.groups[string(key)] = group
+		aggregator.order = append(aggregator.order, string(key))
+	}
+
+	for i, expr := range aggregator.sel.aggregates {
+		group.accumulators[i].step(expr, row)
+	}
+	return nil
+}
+
+func (aggregator *hashAggregator) spill(key []byte, row []Value) error {
+	if aggregator.partitions == nil {
+		aggregator.partitions = make([]*spillPartition, aggregateSpillPartitions)
+	}
+
+	// 每一层使用不同的哈希种子, 保证下一层能继续拆分
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(aggregator.depth)})
+	hash.Write(key)
+	index := hash.Sum32() % aggregateSpillPartitions
+
+	partition := aggregator.partitions[index]
+	if partition == nil {
+		file, err := os.CreateTemp("", "sqlite-groupby-*")
+		if err != nil {
+			return fmt.Errorf("%w: error creating spill file: %v", ErrIO, err)
+		}
+		partition = &spillPartition{file: file, writer: bufio.NewWriter(file)}
+		aggregator.partitions[index] = partition
+	}
+
+	if _, err := partition.writer.Write(appendRow(nil, row)); err != nil {
+		return fmt.Errorf("%w: error writing spill file: %v", ErrIO, err)
+	}
+	return nil
+}
+
+// finish 输出所有分组的结果行, 先输出内存中的分组, 再逐个处理磁盘分区
+func (aggregator *hashAggregator) finish(emit func(row []Value)) error {
+	sel := aggregator.sel
+
+	if len(aggregator.groups) == 0 && len(sel.groupBy) == 0 && aggregator.depth == 0 {
+		// 没有GROUP BY时即使没有输入也要输出一行
+		aggregator.groups[""] = &aggregateGroup{
+			row:          make([]Value, sel.width),
+			accumulators: make([]aggregateAccumulator, len(sel.aggregates)),
+		}
+		aggregator.order = append(aggregator.order, "")
+	}
+
+	for _, key := range aggregator.order {
+		group := aggregator.groups[key]
+
+		results := make([]Value, len(sel.aggregates))
+		for i, expr := range sel.aggregates {
+			results[i] = group.accumulators[i].final(expr)
+		}
+
+		if sel.having != nil && !isTruthy(evalExpr(sel.having, group.row, results)) {
+			continue
+		}
+
+		out := make([]Value, len(sel.columns))
+		for i, column := range sel.columns {
+			out[i] = evalExpr(column.expr, group.row, results)
+		}
+		emit(out)
+	}
+	aggregator.groups = nil
+	aggregator.order = nil
+
+	for i, partition := range aggregator.partitions {
+		if partition == nil {
+			continue
+		}
+		aggregator.partitions[i] = nil
+		if err := aggregator.replay(partition, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (aggregator *hashAggregator) replay(partition *spillPartition, emit func(row []Value)) error {
+	defer partition.remove()
+
+	err := partition.writer.Flush()
+	if err == nil {
+		_, err = partition.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: error rewinding spill file: %v", ErrIO, err)
+	}
+
+	child := newHashAggregator(aggregator.sel, aggregator.depth+1)
+	defer child.close()
+	reader := bufio.NewReader(partition.file)
+	for {
+		row, err := readRow(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: error reading spill file: %v", ErrIO, err)
+		}
+		if err := child.add(row); err != nil {
+			return err
+		}
+	}
+	return child.finish(emit)
+}
+
+// close 删除还没有处理的临时文件, 查询中途出错时调用
+func (aggregator *hashAggregator) close() {
+	for i, partition := range aggregator.partitions {
+		if partition != nil {
+			partition.remove()
+			aggregator.partitions[i] = nil
+		}
+	}
+}
+
+func (partition *spillPartition) remove() {
+	partition.file.Close()
+	os.Remove(partition.file.Name())
+}
+
+// appendValue 编码格式: 1字节类型 + 整数/浮点数8字节 或 4字节长度 + 文本
+func appendValue(buf []byte, value Value) []byte {
+	var scratch [8]byte
+
+	buf = append(buf, byte(value.typ))
+	switch value.typ {
+	case valueInteger:
+		binary.LittleEndian.PutUint64(scratch[:], uint64(value.integer))
+		buf = append(buf, scratch[:8]...)
+	case valueReal:
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(value.real))
+		buf = append(buf, scratch[:8]...)
+	case valueText:
+		binary.LittleEndian.PutUint32(scratch[:], uint32(len(value.text)))
+		buf = append(buf, scratch[:4]...)
+		buf = append(buf, value.text...)
+	}
+	return buf
+}
+
+func appendRow(buf []byte, row []Value) []byte {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(len(row)))
+	buf = append(buf, scratch[:]...)
+	for _, value := range row {
+		buf = appendValue(buf, value)
+	}
+	return buf
+}
+
+func readValue(reader *bufio.Reader) (Value, error) {
+	typ, err := reader.ReadByte()
+	if err != nil {
+		return Value{}, err
+	}
+
+	var buf [8]byte
+	switch valueType(typ) {
+	case valueNull:
+		return nullValue(), nil
+	case valueInteger:
+		if _, err := io.ReadFull(reader, buf[:8]); err != nil {
+			return Value{}, err
+		}
+		return integerValue(int64(binary.LittleEndian.Uint64(buf[:8]))), nil
+	case valueReal:
+		if _, err := io.ReadFull(reader, buf[:8]); err != nil {
+			return Value{}, err
+		}
+		return realValue(math.Float64frombits(binary.LittleEndian.Uint64(buf[:8]))), nil
+	case valueText:
+		if _, err := io.ReadFull(reader, buf[:4]); err != nil {
+			return Value{}, err
+		}
+		text := make([]byte, binary.LittleEndian.Uint32(buf[:4]))
+		if _, err := io.ReadFull(reader, text); err != nil {
+			return Value{}, err
+		}
+		return textValue(string(text)), nil
+	}
+	return Value{}, fmt.Errorf("unknown value type %d", typ)
+}
+
+func readRow(reader *bufio.Reader) ([]Value, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(reader, buf[:]); err != nil {
+		return nil, err
+	}
+
+	row := make([]Value, binary.LittleEndian.Uint32(buf[:]))
+	for i := range row {
+		value, err := readValue(reader)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		row[i] = value
+	}
+	return row, nil
+}
@@ -0,0 +1,175 @@
+package minisqlite
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// statTableName analyze收集的统计保存在这张表中, 每张表一行:
+// id 为表的根页号, username 为表名, email 为 "行数 每列不同值的个数..."
+const statTableName = "sqlite_stat1"
+
+// tableStatistics 一张表的统计, distinct 与tableColumns对应
+type tableStatistics struct {
+	rows     float64
+	distinct []float64
+}
+
+// @Analyze analyze [table]
+func prepareAnalyze(input string, statement *sqlStatement) PrepareResult {
+	statement.typ = statementAnalyze
+
+	parser, ok := newParser(input)
+	if !ok || !parser.acceptKeyword("analyze") {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	if token := parser.peek(); token.typ == tokenIdentifier && !isReserved(token) {
+		statement.tableName = parser.next().text
+	}
+
+	parser.acceptOperator(";")
+	if !parser.atEnd() {
+		return PREPARE_SYNTAX_ERROR
+	}
+	return PREPARE_SUCCESS
+}
+
+// executeAnalyze 统计表的行数和每列不同值的个数, 写入sqlite_stat1. name 为空时统计所有表
+func executeAnalyze(pager *filePager, name string) error {
+	var tables []*btree
+	if name != "" {
+		table, err := findTable(pager, name)
+		if err != nil {
+			return err
+		}
+		if table == nil {
+			return EXECUTE_UNKNOWN_TABLE
+		}
+		tables = append(tables, table)
+	} else {
+		tables = append(tables, &btree{pager: pager, rootPageNum: 0, name: defaultTableName})
+		catalog, err := catalogTable(pager)
+		if err != nil {
+			return err
+		}
+		if catalog != nil {
+			err := scanTable(catalog, func(row *tableRow) error {
+				if name := cString(row.username[:]); !strings.EqualFold(name, statTableName) {
+					tables = append(tables, &btree{pager: pager, rootPageNum: row.id, name: name})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	rows := make([]tableRow, len(tables))
+	for i, table := range tables {
+		stats, err := collectTableStats(table)
+		if err != nil {
+			return err
+		}
+		fields := []string{strconv.FormatFloat(stats.rows, 'f', -1, 64)}
+		for _, distinct := range stats.distinct[1:] {
+			fields = append(fields, strconv.FormatFloat(distinct, 'f', -1, 64))
+		}
+
+		rows[i].id = table.rootPageNum
+		copy(rows[i].username[:], table.name)
+		copy(rows[i].email[:], strings.Join(fields, " "))
+	}
+
+	statTable, err := findTable(pager, statTableName)
+	if err != nil {
+		return err
+	}
+	if statTable == nil {
+		if err := executeCreateTable(pager, statTableName); err != nil {
+			return err
+		}
+		if statTable, err = findTable(pager, statTableName); err != nil {
+			return err
+		}
+	}
+	for i := range rows {
+		if err := replaceRow(statTable, &rows[i]); err != nil {
+			return err
+		}
+	}
+	atomic.AddUint32(&pager.schemaGeneration, 1)
+	return nil
+}
+
+// collectTableStats 扫描整张表, 用hashKey统计每列不同值的个数
+func collectTableStats(table *btree) (*tableStatistics, error) {
+	seen := make([]map[string]bool, len(tableColumns))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	stats := &tableStatistics{}
+	err := scanTable(table, func(row *tableRow) error {
+		stats.rows++
+		for i, value := range rowValues(row) {
+			seen[i][hashKey(value)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range seen {
+		stats.distinct = append(stats.distinct, float64(len(seen[i])))
+	}
+	return stats, nil
+}
+
+// loadStats 读取sqlite_stat1, 键为小写的表名. 没有统计时返回nil, 格式不对的行被忽略
+func loadStats(pager *filePager) (map[string]*tableStatistics, error) {
+	statTable, err := findTable(pager, statTableName)
+	if statTable == nil || err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*tableStatistics)
+	err = scanTable(statTable, func(row *tableRow) error {
+		fields := strings.Fields(cString(row.email[:]))
+		if len(fields) != len(tableColumns) {
+			return nil
+		}
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil || value < 0 {
+				return nil
+			}
+			values[i] = value
+		}
+		// id 每行都不同, 不保存它的不同值个数
+		stats[strings.ToLower(cString(row.username[:]))] = &tableStatistics{
+			rows:     values[0],
+			distinct: append([]float64{values[0]}, values[1:]...),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readStats 在读锁下读取统计, 用于准备语句时选择查询计划, 结果缓存在DB中
+func readStats(table *btree) (map[string]*tableStatistics, error) {
+	table.pager.lock.RLock()
+	defer table.pager.lock.RUnlock()
+	if err := table.pager.beginRead(); err != nil {
+		return nil, err
+	}
+	defer table.pager.endRead()
+
+	return loadStats(table.pager)
+}
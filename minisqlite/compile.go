@@ -0,0 +1,301 @@
+package minisqlite
+
+import (
+	"fmt"
+	"strings"
+)
+
+var comparisonOpcodes = map[string]vmOpcode{
+	"=":  opEq,
+	"!=": opNe,
+	"<":  opLt,
+	"<=": opLe,
+	">":  opGt,
+	">=": opGe,
+}
+
+// compiler 生成vmProgram, 跳转到还没有生成的位置时先使用标签, 最后统一替换为地址
+type compiler struct {
+	program *vmProgram
+	// labels 标签对应的地址, 还没有确定时为-1
+	labels []int
+}
+
+// compileStatement 把解析后的语句编译为字节码程序
+func compileStatement(statement *sqlStatement) *vmProgram {
+	c := &compiler{program: &vmProgram{}}
+
+	switch statement.typ {
+	case statementInsert:
+		c.compileInsert(statement)
+	case statementSelect:
+		c.compileSelect(statement.sel)
+	case statementCreateTable:
+		c.emit(opCreateTable, 0, 0, 0, statement.tableName)
+	case statementAnalyze:
+		c.emit(opAnalyze, 0, 0, 0, statement.tableName)
+	case statementBegin, statementCommit, statementRollback:
+		c.emit(opTransaction, int(statement.typ), 0, 0, nil)
+	}
+	c.emit(opHalt, 0, 0, 0, nil)
+
+	c.resolveJumps()
+	return c.program
+}
+
+func (c *compiler) emit(opcode vmOpcode, p1, p2, p3 int, p4 interface{}) int {
+	c.program.instructions = append(c.program.instructions, instruction{opcode: opcode, p1: p1, p2: p2, p3: p3, p4: p4})
+	return len(c.program.instructions) - 1
+}
+
+func (c *compiler) address() int {
+	return len(c.program.instructions)
+}
+
+func (c *compiler) newRegisters(n int) int {
+	base := c.program.numRegisters
+	c.program.numRegisters += n
+	return base
+}
+
+// newLabel 返回一个负数作为跳转目标, 用resolveLabel确定它的地址
+func (c *compiler) newLabel() int {
+	c.labels = append(c.labels, -1)
+	return -len(c.labels)
+}
+
+func (c *compiler) resolveLabel(label int) {
+	c.labels[-label-1] = c.address()
+}
+
+func (c *compiler) resolveJumps() {
+	for i := range c.program.instructions {
+		if in := &c.program.instructions[i]; in.p2 < 0 {
+			in.p2 = c.labels[-in.p2-1]
+		}
+	}
+}
+
+func (c *compiler) compileInsert(statement *sqlStatement) {
+	base := c.newRegisters(len(tableColumns))
+	if statement.insertValues != nil {
+		for i, expr := range statement.insertValues {
+			c.compileExprTo(expr, base+i)
+		}
+	} else {
+		for i, value := range rowValues(&statement.rowToInsert) {
+			c.emit(opValue, 0, 0, base+i, value)
+		}
+	}
+
+	name := statement.tableName
+	if name == "" {
+		name = defaultTableName
+	}
+	c.program.numCursors = 1
+	c.program.cursorNames = []string{name}
+	c.emit(opOpenWrite, 0, 0, 0, name)
+	c.emit(opInsert, 0, base, 0, nil)
+}
+
+func (c *compiler) compileSelect(sel *selectStatement) {
+	if sel == nil {
+		sel = &selectStatement{star: true, from: []tableRef{{name: defaultTableName}}}
+		resolveSelect(sel)
+	}
+	if sel.plan == nil {
+		planSelect(sel, nil)
+	}
+
+	// 游标k对应FROM中的第k张表, 连接行占用最前面的寄存器. 循环的顺序由sel.plan决定
+	c.program.width = sel.width
+	c.newRegisters(sel.width)
+	c.program.numCursors = len(sel.from)
+	for k, ref := range sel.from {
+		name := ref.name
+		if ref.alias != "" {
+			name += " AS " + ref.alias
+		}
+		c.program.cursorNames = append(c.program.cursorNames, name)
+		c.emit(opOpenRead, k, 0, 0, ref.name)
+	}
+
+	if len(sel.from) == 0 {
+		c.addPlan("SCAN CONSTANT ROW")
+	}
+	c.compileJoin(sel, 0)
+	if sel.isAggregate() {
+		if len(sel.groupBy) > 0 {
+			c.addPlan("USE HASH TABLE FOR GROUP BY")
+		}
+		c.emit(opAggFinal, 0, 0, 0, sel)
+	}
+}
+
+// compileJoin 按查询计划为第level层循环访问的表生成循环.
+// LEFT JOIN 没有匹配的行时用opNullRow补一行NULL再执行内层循环
+func (c *compiler) compileJoin(sel *selectStatement, level int) {
+	if level == len(sel.plan.loops) {
+		c.compileOutput(sel)
+		return
+	}
+
+	loop := &sel.plan.loops[level]
+	table := loop.table
+	left := sel.from[table].joinType == joinLeft
+	matched := 0
+	if left {
+		matched = c.newRegisters(1)
+		c.emit(opInteger, 0, 0, matched, nil)
+	}
+	done, next, body := c.newLabel(), c.newLabel(), c.newLabel()
+
+	c.addPlan(c.accessDetail(loop))
+	upper := 0
+	switch loop.typ {
+	case accessRowidEq:
+		c.emit(opSeekRowid, table, done, c.compileExpr(loop.key), nil)
+	case accessRowidRange:
+		if loop.upper != nil {
+			upper = c.compileExpr(loop.upper)
+		}
+		if loop.lower != nil {
+			c.emit(opSeekGe, table, done, c.compileExpr(loop.lower), nil)
+		} else {
+			c.emit(opRewind, table, done, 0, nil)
+		}
+	case accessHash:
+		c.emit(opHashBuild, table, 0, 0, loop.hashInner)
+		c.emit(opHashProbe, table, done, c.compileExpr(loop.hashOuter), nil)
+	default:
+		c.emit(opRewind, table, done, 0, nil)
+	}
+
+	top := c.address()
+	c.compileColumns(table)
+	// 主键按顺序排列, 超过上界后不会再有满足条件的行
+	if loop.typ == accessRowidRange && loop.upper != nil {
+		c.emit(comparisonOpcodes[loop.upperOp], table*len(tableColumns), done, upper, nil)
+	}
+	for _, filter := range loop.filters {
+		c.compileFilter(filter, next)
+	}
+	c.resolveLabel(body)
+	if left {
+		c.emit(opInteger, 1, 0, matched, nil)
+	}
+	c.compileJoin(sel, level+1)
+
+	c.resolveLabel(next)
+	switch loop.typ {
+	case accessRowidEq:
+	case accessHash:
+		c.emit(opHashNext, table, top, 0, nil)
+	default:
+		c.emit(opNext, table, top, 0, nil)
+	}
+	c.resolveLabel(done)
+
+	if left {
+		end := c.newLabel()
+		c.emit(opIf, matched, end, 0, nil)
+		c.emit(opNullRow, table, 0, 0, nil)
+		c.compileColumns(table)
+		c.emit(opGoto, 0, body, 0, nil)
+		c.resolveLabel(end)
+	}
+}
+
+// accessDetail explain query plan 中描述一层循环的文字
+func (c *compiler) accessDetail(loop *accessPath) string {
+	name := c.program.cursorNames[loop.table]
+	switch loop.typ {
+	case accessRowidEq:
+		return fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (id=?)", name)
+	case accessRowidRange:
+		var bounds []string
+		if loop.lower != nil {
+			bounds = append(bounds, "id"+loop.lowerOp+"?")
+		}
+		if loop.upper != nil {
+			bounds = append(bounds, "id"+loop.upperOp+"?")
+		}
+		return fmt.Sprintf("SEARCH %s USING INTEGER PRIMARY KEY (%s)", name, strings.Join(bounds, " AND "))
+	case accessHash:
+		return fmt.Sprintf("SEARCH %s USING AUTOMATIC HASH INDEX (%s=?)", name, exprString(loop.hashInner))
+	}
+	return "SCAN " + name
+}
+
+// addPlan 记录explain query plan的一行, 对应接下来生成的指令
+func (c *compiler) addPlan(detail string) {
+	c.program.plan = append(c.program.plan, planStep{addr: c.address(), detail: detail})
+}
+
+// compileOutput 最内层循环: 过滤引用了LEFT JOIN的表的WHERE条件, 然后输出结果行或加入聚合
+func (c *compiler) compileOutput(sel *selectStatement) {
+	next := c.newLabel()
+	for _, filter := range sel.plan.filters {
+		c.compileFilter(filter, next)
+	}
+
+	if sel.isAggregate() {
+		c.emit(opAggStep, 0, 0, 0, sel)
+	} else {
+		base := c.newRegisters(len(sel.columns))
+		for i, column := range sel.columns {
+			c.compileExprTo(column.expr, base+i)
+		}
+		c.emit(opResultRow, base, len(sel.columns), 0, nil)
+	}
+	c.resolveLabel(next)
+}
+
+// compileColumns 把游标table的所有列读入连接行中对应的寄存器
+func (c *compiler) compileColumns(table int) {
+	for i := range tableColumns {
+		c.emit(opColumn, table, i, table*len(tableColumns)+i, nil)
+	}
+}
+
+// compileFilter 条件不为真时跳转到falseLabel. AND 拆成多个条件, 比较编译为比较指令
+func (c *compiler) compileFilter(expr *exprNode, falseLabel int) {
+	if expr == nil {
+		return
+	}
+	if expr.typ == exprBinary {
+		if expr.op == "and" {
+			c.compileFilter(expr.left, falseLabel)
+			c.compileFilter(expr.right, falseLabel)
+			return
+		}
+		if opcode, ok := comparisonOpcodes[expr.op]; ok {
+			left := c.compileExpr(expr.left)
+			right := c.compileExpr(expr.right)
+			c.emit(opcode, left, falseLabel, right, nil)
+			return
+		}
+	}
+	c.emit(opIfNot, c.compileExpr(expr), falseLabel, 0, nil)
+}
+
+// compileExpr 返回保存表达式结果的寄存器, 列引用直接使用连接行中的寄存器
+func (c *compiler) compileExpr(expr *exprNode) int {
+	if expr.typ == exprColumn {
+		return expr.columnIndex
+	}
+	register := c.newRegisters(1)
+	c.compileExprTo(expr, register)
+	return register
+}
+
+func (c *compiler) compileExprTo(expr *exprNode, register int) {
+	switch expr.typ {
+	case exprColumn:
+		c.emit(opCopy, expr.columnIndex, 0, register, nil)
+	case exprLiteral:
+		c.emit(opValue, 0, 0, register, expr.value)
+	default:
+		c.emit(opExpr, 0, 0, register, expr)
+	}
+}
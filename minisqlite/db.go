@@ -0,0 +1,500 @@
+package minisqlite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 存储层的错误都包装了下面的哨兵错误, 调用方用errors.Is判断
+var (
+	// ErrCorrupt 文件内容不是合法的数据库, 例如页号越界或节点类型错误
+	ErrCorrupt = errors.New("database disk image is malformed")
+	// ErrIO 读写数据库文件或临时文件失败
+	ErrIO = errors.New("disk I/O error")
+	// ErrFull 没有空闲页面, 或需要分裂内部节点
+	ErrFull = errors.New("database or disk is full")
+)
+
+var prepareMessages = map[PrepareResult]string{
+	PREPARE_NEGATIVE_ID:            "ID must be positive",
+	PREPARE_STRING_TOO_LONG:        "string is too long",
+	PREPARE_UNRECOGNIZED_STATEMENT: "unrecognized statement",
+	PREPARE_SYNTAX_ERROR:           "syntax error",
+	PREPARE_UNKNOWN_COLUMN:         "no such column",
+	PREPARE_AMBIGUOUS_COLUMN:       "ambiguous column name",
+}
+
+var executeMessages = map[ExecuteResult]string{
+	EXECUTE_DUPLICATE_KEY:      "duplicate key",
+	EXECUTE_TABLE_FULL:         "table full",
+	EXECUTE_UNKNOWN_TABLE:      "no such table",
+	EXECUTE_TABLE_EXISTS:       "table already exists",
+	EXECUTE_READONLY_TABLE:     "table may not be modified",
+	EXECUTE_READONLY_DATABASE:  "attempt to write a readonly database",
+	EXECUTE_NEGATIVE_ID:        "ID must be positive",
+	EXECUTE_STRING_TOO_LONG:    "string is too long",
+	EXECUTE_TYPE_MISMATCH:      "datatype mismatch",
+	EXECUTE_TRANSACTION_ACTIVE: "cannot start a transaction within a transaction",
+	EXECUTE_NO_TRANSACTION:     "no transaction is active",
+}
+
+// Error 使PrepareResult可以作为error返回, 调用方用errors.As取回具体的结果
+func (result PrepareResult) Error() string {
+	if message, ok := prepareMessages[result]; ok {
+		return message
+	}
+	return fmt.Sprintf("prepare result %d", int(result))
+}
+
+func (result ExecuteResult) Error() string {
+	if message, ok := executeMessages[result]; ok {
+		return message
+	}
+	return fmt.Sprintf("execute result %d", int(result))
+}
+
+// Is 使errors.Is(EXECUTE_TABLE_FULL, ErrFull)成立
+func (result ExecuteResult) Is(target error) bool {
+	return result == EXECUTE_TABLE_FULL && target == ErrFull
+}
+
+func prepareError(result PrepareResult) error {
+	if result == PREPARE_SUCCESS {
+		return nil
+	}
+	return result
+}
+
+// Options 打开数据库时的选项, 传nil使用默认值
+type Options struct {
+	// ReadOnly 只读打开, 文件不存在时报错, 写语句返回EXECUTE_READONLY_DATABASE
+	ReadOnly bool
+	// BusyTimeout 其他进程持有冲突的锁时最多等待的时间, 超时返回ErrBusy, 默认不等待
+	BusyTimeout time.Duration
+}
+
+// DB 一个打开的数据库文件, 可以被多个goroutine同时使用:
+// 查询并发执行, 写语句依次执行. Close 不能和其他调用同时进行.
+type DB struct {
+	table *btree
+
+	// statsMu 保护analyze统计的缓存, 准备语句时按统计选择查询计划.
+	// 缓存在pager的schemaGeneration变化时失效, 见filePager.schemaGeneration
+	statsMu         sync.Mutex
+	stats           map[string]*tableStatistics
+	statsLoaded     bool
+	statsGeneration uint32
+}
+
+func Open(path string, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	table, err := dbOpen(path, opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	table.pager.busyTimeout = opts.BusyTimeout
+	return &DB{table: table}, nil
+}
+
+func (db *DB) Close() error {
+	if db.table == nil {
+		return errors.New("database is closed")
+	}
+	err := db.table.dbClose()
+	db.table = nil
+	return err
+}
+
+// Prepare 解析一次语句, 返回的Stmt可以绑定不同的参数反复执行
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	if db.table == nil {
+		return nil, errors.New("database is closed")
+	}
+
+	statement, result := prepare(db.table, query)
+	if err := prepareError(result); err != nil {
+		return nil, err
+	}
+	if statement.statement.sel != nil {
+		statement.replan(db.plannerStats())
+	}
+	return &Stmt{statement: statement}, nil
+}
+
+// plannerStats 返回缓存的analyze统计, 缓存失效后重新读取sqlite_stat1, 读取失败时不使用统计
+func (db *DB) plannerStats() map[string]*tableStatistics {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	pager := db.table.pager
+	generation := atomic.LoadUint32(&pager.schemaGeneration)
+	if db.statsLoaded && generation == db.statsGeneration {
+		return db.stats
+	}
+
+	stats, err := readStats(db.table)
+	if err != nil {
+		return nil
+	}
+	db.stats = stats
+	db.statsLoaded = true
+	db.statsGeneration = generation
+	return stats
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (Result, error) {
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return Result{}, err
+	}
+	return stmt.Exec(args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*Rows, error) {
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(args...)
+}
+
+// read 持有读锁执行fn
+func (db *DB) read(fn func(pager *filePager) error) error {
+	if db.table == nil {
+		return errors.New("database is closed")
+	}
+
+	pager := db.table.pager
+	pager.lock.RLock()
+	defer pager.lock.RUnlock()
+	if err := pager.beginRead(); err != nil {
+		return err
+	}
+	defer pager.endRead()
+
+	return fn(pager)
+}
+
+// Tables 返回所有表的名字, 包括users和系统表
+func (db *DB) Tables() ([]string, error) {
+	var tables []string
+	err := db.read(func(pager *filePager) error {
+		tables = append(tables, defaultTableName)
+		catalog, err := catalogTable(pager)
+		if catalog == nil || err != nil {
+			return err
+		}
+		tables = append(tables, catalogTableName)
+		return scanTable(catalog, func(row *tableRow) error {
+			tables = append(tables, cString(row.username[:]))
+			return nil
+		})
+	})
+	return tables, err
+}
+
+// Columns 返回表的列名, 所有表的列都相同
+func (db *DB) Columns(table string) ([]string, error) {
+	var columns []string
+	err := db.read(func(pager *filePager) error {
+		found, err := findTable(pager, table)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return EXECUTE_UNKNOWN_TABLE
+		}
+		columns = append(columns, tableColumns...)
+		return nil
+	})
+	return columns, err
+}
+
+// PrintTree 把users表的B树结构写到w
+func (db *DB) PrintTree(w io.Writer) error {
+	return db.read(func(pager *filePager) error {
+		return printTree(w, pager, 0, 0)
+	})
+}
+
+// PrintTreeDot 把users表的B树写成Graphviz DOT
+func (db *DB) PrintTreeDot(w io.Writer) error {
+	return db.read(func(pager *filePager) error {
+		tree, err := loadTree(pager, 0, make(map[uint32]bool))
+		if err != nil {
+			return err
+		}
+		return printTreeDot(w, tree)
+	})
+}
+
+// PrintTreeJSON 把users表的B树写成JSON, 每个页面为一个treeNode
+func (db *DB) PrintTreeJSON(w io.Writer) error {
+	return db.read(func(pager *filePager) error {
+		tree, err := loadTree(pager, 0, make(map[uint32]bool))
+		if err != nil {
+			return err
+		}
+		return printTreeJSON(w, tree)
+	})
+}
+
+// PrintPage 解码第pageNum页的头部, 单元和未使用的空间
+func (db *DB) PrintPage(w io.Writer, pageNum uint32) error {
+	return db.read(func(pager *filePager) error {
+		return printPage(w, pager, pageNum)
+	})
+}
+
+// Check 检查所有表的B树结构和页面校验和, 返回发现的问题, 数据库完好时返回空
+func (db *DB) Check() ([]string, error) {
+	var problems []string
+	err := db.read(func(pager *filePager) error {
+		var err error
+		problems, err = integrityCheck(pager)
+		return err
+	})
+	return problems, err
+}
+
+// NamedArg 按名字绑定 :name 形式的参数
+type NamedArg struct {
+	Name  string
+	Value interface{}
+}
+
+func Named(name string, value interface{}) NamedArg {
+	return NamedArg{Name: name, Value: value}
+}
+
+// Stmt 绑定参数会修改语句, 同一个Stmt不能被多个goroutine同时使用
+type Stmt struct {
+	statement *preparedStatement
+}
+
+// ParameterCount 返回语句中最大的参数下标
+func (stmt *Stmt) ParameterCount() int {
+	return stmt.statement.parameterCount()
+}
+
+// bind 依次绑定参数, NamedArg按名字绑定, 其余按位置从1开始绑定
+func (stmt *Stmt) bind(args []interface{}) error {
+	stmt.statement.reset()
+	for i, arg := range args {
+		var err error
+		if named, ok := arg.(NamedArg); ok {
+			err = stmt.statement.bindNamed(named.Name, named.Value)
+		} else {
+			err = stmt.statement.bind(i+1, arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exec 执行语句, select的结果被丢弃
+func (stmt *Stmt) Exec(args ...interface{}) (Result, error) {
+	if err := stmt.bind(args); err != nil {
+		return Result{}, err
+	}
+
+	statement := &stmt.statement.statement
+	if err := stmt.statement.execute(); err != nil {
+		return Result{}, err
+	}
+	if statement.typ == statementInsert && statement.explain == explainNone {
+		return Result{LastInsertId: int64(statement.rowToInsert.id), RowsAffected: 1}, nil
+	}
+	return Result{}, nil
+}
+
+// Query 执行语句, 查询的结果行在Rows.Next中逐行取出; 不是select的语句返回空结果.
+// 第一行在这里取出, 打开表时的错误直接返回. Rows关闭之前不能再次执行同一个Stmt
+func (stmt *Stmt) Query(args ...interface{}) (*Rows, error) {
+	if err := stmt.bind(args); err != nil {
+		return nil, err
+	}
+
+	statement := &stmt.statement.statement
+	rows := &Rows{}
+	if statement.explain != explainNone {
+		rows.columns = statement.explainColumns()
+		rows.values = statement.explainRows()
+		return rows, nil
+	}
+	if statement.typ != statementSelect {
+		if err := stmt.statement.execute(); err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	rows.columns = statement.sel.columnNames()
+	vm, release, err := openSelect(statement, stmt.statement.table)
+	if err != nil {
+		return nil, err
+	}
+	rows.vm, rows.release = vm, release
+	if rows.pending, err = vm.step(); err != nil || rows.pending == nil {
+		rows.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (stmt *Stmt) Close() error {
+	return nil
+}
+
+type Result struct {
+	LastInsertId int64
+	RowsAffected int64
+}
+
+// Rows 查询结果的迭代器, 先调用Next再读取当前行.
+// 查询在Next中逐行执行, 期间持有数据库的读锁, 直到Next返回false或调用Close.
+// 持有读锁时写语句会一直等待, 同一个goroutine要先关闭Rows再写入
+type Rows struct {
+	columns []string
+	// values 已经全部取出的结果(explain), 按next逐行返回
+	values [][]Value
+	next   int
+
+	// vm 正在执行的查询, pending 为已经取出还没有返回的一行
+	vm      *virtualMachine
+	release func()
+	pending []Value
+
+	current []Value
+	err     error
+}
+
+func (rows *Rows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *Rows) Next() bool {
+	rows.current = nil
+	if rows.vm == nil {
+		if rows.next >= len(rows.values) {
+			return false
+		}
+		rows.current = rows.values[rows.next]
+		rows.next++
+		return true
+	}
+
+	row := rows.pending
+	rows.pending = nil
+	if row == nil {
+		row, rows.err = rows.vm.step()
+	}
+	if row == nil {
+		rows.Close()
+		return false
+	}
+	rows.current = row
+	return true
+}
+
+// Err 返回执行查询时遇到的错误, Next返回false后调用
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Values 返回当前行的值
+func (rows *Rows) Values() []Value {
+	return rows.current
+}
+
+// Scan 把当前行的值依次写入dest, 支持*int64 *int *float64 *string *[]byte *interface{} *Value
+func (rows *Rows) Scan(dest ...interface{}) error {
+	if rows.current == nil {
+		return errors.New("Scan called without calling Next")
+	}
+	if len(dest) != len(rows.current) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(rows.current), len(dest))
+	}
+
+	for i, value := range rows.current {
+		switch d := dest[i].(type) {
+		case *Value:
+			*d = value
+		case *interface{}:
+			*d = value.Interface()
+		case *int64:
+			*d = value.Int64()
+		case *int:
+			*d = int(value.Int64())
+		case *float64:
+			*d = value.Float64()
+		case *string:
+			*d = value.Text()
+		case *[]byte:
+			*d = []byte(value.Text())
+		default:
+			return fmt.Errorf("unsupported Scan destination %T", dest[i])
+		}
+	}
+	return nil
+}
+
+// Close 结束查询并释放读锁, 可以重复调用
+func (rows *Rows) Close() error {
+	if rows.release != nil {
+		rows.release()
+		rows.release = nil
+	}
+	rows.vm = nil
+	rows.values, rows.pending, rows.current = nil, nil, nil
+	return nil
+}
+
+func (value Value) IsNull() bool {
+	return value.typ == valueNull
+}
+
+// Int64 按数值取值, REAL截断, TEXT按数字前缀解析
+func (value Value) Int64() int64 {
+	n := value.numeric()
+	if n.typ == valueReal {
+		return int64(n.real)
+	}
+	return n.integer
+}
+
+func (value Value) Float64() float64 {
+	return value.numeric().float()
+}
+
+// Text 返回文本形式, NULL为空字符串
+func (value Value) Text() string {
+	if value.typ == valueNull {
+		return ""
+	}
+	return value.String()
+}
+
+// Interface 转换为Go的值: nil, int64, float64 或 string
+func (value Value) Interface() interface{} {
+	switch value.typ {
+	case valueInteger:
+		return value.integer
+	case valueReal:
+		return value.real
+	case valueText:
+		return value.text
+	}
+	return nil
+}
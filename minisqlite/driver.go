@@ -0,0 +1,290 @@
+package minisqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+const DRIVER_NAME = "minisqlite"
+
+func init() {
+	sql.Register(DRIVER_NAME, &sqliteDriver{})
+}
+
+// sharedDatabase 同一进程内打开同一个文件的连接共用一个btree, 避免各自缓存页面互相覆盖
+type sharedDatabase struct {
+	path string
+	db   *DB
+	refs int
+	// mu 写锁, 同一时间只有一个连接在写, 事务期间一直持有.
+	// 查询不取这个锁, 由pager的读锁和sharedLock同步, 所以同一进程的其他连接能读到事务中未提交的修改
+	mu sync.Mutex
+}
+
+var (
+	databasesMu sync.Mutex
+	databases   = make(map[string]*sharedDatabase)
+)
+
+// sqliteDriver 实现database/sql/driver.Driver, 数据源名称为数据库文件路径
+type sqliteDriver struct{}
+
+func (d *sqliteDriver) Open(name string) (driver.Conn, error) {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	shared := databases[path]
+	if shared == nil {
+		db, err := Open(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		shared = &sharedDatabase{path: path, db: db}
+		databases[path] = shared
+	}
+	shared.refs++
+	return &conn{db: shared}, nil
+}
+
+type conn struct {
+	db *sharedDatabase
+	tx *tx
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	statement, err := c.db.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, query)
+	}
+	return &stmt{conn: c, statement: statement}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		c.tx.Rollback()
+	}
+
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+
+	c.db.refs--
+	if c.db.refs == 0 {
+		c.db.db.Close()
+		delete(databases, c.db.path)
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// 事务都是可写的, 隔离级别固定
+	if opts.ReadOnly {
+		return nil, errors.New("read-only transactions are not supported")
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, fmt.Errorf("isolation level %s is not supported", sql.IsolationLevel(opts.Isolation))
+	}
+	if c.tx != nil {
+		return nil, EXECUTE_TRANSACTION_ACTIVE
+	}
+
+	c.db.mu.Lock()
+	if err := executeStatement(&sqlStatement{typ: statementBegin}, c.db.db.table); err != nil {
+		c.db.mu.Unlock()
+		return nil, err
+	}
+	c.tx = &tx{conn: c}
+	return c.tx, nil
+}
+
+// run 在连接上执行fn, 写语句不在事务中时先取得写锁
+func (c *conn) run(write bool, fn func() error) error {
+	if write && c.tx == nil {
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+	}
+	return fn()
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) finish(typ statementType) error {
+	c := t.conn
+	if c.tx != t {
+		return EXECUTE_NO_TRANSACTION
+	}
+	defer c.db.mu.Unlock()
+
+	c.tx = nil
+	err := executeStatement(&sqlStatement{typ: typ}, c.db.db.table)
+	if err != nil && typ == statementCommit {
+		// 提交失败时事务仍然打开, 回滚后再释放锁
+		executeStatement(&sqlStatement{typ: statementRollback}, c.db.db.table)
+	}
+	return err
+}
+
+func (t *tx) Commit() error {
+	return t.finish(statementCommit)
+}
+
+func (t *tx) Rollback() error {
+	return t.finish(statementRollback)
+}
+
+type stmt struct {
+	conn      *conn
+	statement *Stmt
+}
+
+func (s *stmt) Close() error {
+	return s.statement.Close()
+}
+
+func (s *stmt) NumInput() int {
+	return s.statement.ParameterCount()
+}
+
+// args 命名参数转换为NamedArg, Ordinal与位置一致
+func (s *stmt) args(named []driver.NamedValue) []interface{} {
+	args := make([]interface{}, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+		if arg.Name != "" {
+			args[i] = Named(arg.Name, arg.Value)
+		}
+	}
+	return args
+}
+
+// isWrite 执行时需要取得写锁的语句
+func (s *stmt) isWrite() bool {
+	statement := &s.statement.statement.statement
+	return statement.isWrite() && statement.explain == explainNone
+}
+
+// checkTransaction 事务需要在连接上持有锁, 只能通过DB.Begin开始
+func (s *stmt) checkTransaction() error {
+	switch s.statement.statement.statement.typ {
+	case statementBegin, statementCommit, statementRollback:
+		return errors.New("use DB.Begin, Tx.Commit and Tx.Rollback to control transactions")
+	}
+	return nil
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.checkTransaction(); err != nil {
+		return nil, err
+	}
+
+	var res Result
+	err := s.conn.run(s.isWrite(), func() error {
+		var err error
+		res, err = s.statement.Exec(s.args(args)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result{lastInsertId: res.LastInsertId, rowsAffected: res.RowsAffected}, nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.checkTransaction(); err != nil {
+		return nil, err
+	}
+
+	result := &rows{}
+	err := s.conn.run(s.isWrite(), func() error {
+		r, err := s.statement.Query(s.args(args)...)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		result.columns = r.Columns()
+		for r.Next() {
+			result.values = append(result.values, r.Values())
+		}
+		return r.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type result struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertId, nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows 查询结果在执行时全部取出, 不会在遍历期间持有数据库的读锁, 遍历时可以在事务中继续写入
+type rows struct {
+	columns []string
+	values  [][]Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	r.values = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	for i, value := range r.values[0] {
+		dest[i] = value.Interface()
+	}
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,89 @@
+package minisqlite
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump 把数据库写成可以在REPL中重新执行的语句: 先是users表的数据, 再依次是每张表的建表语句和数据
+func (db *DB) Dump(w io.Writer) error {
+	return db.read(func(pager *filePager) error {
+		if _, err := fmt.Fprintf(w, "begin transaction;\n"); err != nil {
+			return err
+		}
+
+		users, err := findTable(pager, defaultTableName)
+		if err != nil {
+			return err
+		}
+		if err := dumpTable(w, users); err != nil {
+			return err
+		}
+
+		catalog, err := catalogTable(pager)
+		if err != nil {
+			return err
+		}
+		if catalog != nil {
+			// 先读出所有表, 避免在系统表的游标中再遍历其他表
+			var tables []*btree
+			var schemas []string
+			err := scanTable(catalog, func(row *tableRow) error {
+				tables = append(tables, &btree{pager: pager, rootPageNum: row.id, name: cString(row.username[:])})
+				schemas = append(schemas, cString(row.email[:]))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for i, table := range tables {
+				if _, err := fmt.Fprintf(w, "%s;\n", schemas[i]); err != nil {
+					return err
+				}
+				if err := dumpTable(w, table); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = fmt.Fprintf(w, "commit;\n")
+		return err
+	})
+}
+
+func dumpTable(w io.Writer, table *btree) error {
+	return scanTable(table, func(row *tableRow) error {
+		_, err := fmt.Fprintf(w, "insert into %s values (%d, %s, %s);\n",
+			table.name, row.id, quoteText(cString(row.username[:])), quoteText(cString(row.email[:])))
+		return err
+	})
+}
+
+// scanTable 按键的顺序对表中的每一行调用fn
+func scanTable(table *btree, fn func(row *tableRow) error) error {
+	cursor, err := tableStart(table)
+	if err != nil {
+		return err
+	}
+
+	var row tableRow
+	for !cursor.endOfTable {
+		if err := cursor.cursorRow(&row); err != nil {
+			return err
+		}
+		if err := fn(&row); err != nil {
+			return err
+		}
+		if err := cursor.cursorAdvance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteText 字符串字面量, 单引号写成两个单引号
+func quoteText(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
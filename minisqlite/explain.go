@@ -0,0 +1,225 @@
+package minisqlite
+
+import (
+	"fmt"
+	"strings"
+)
+
+type explainMode int
+
+const (
+	explainNone explainMode = iota
+	// explainProgram explain <stmt> 列出编译后的指令
+	explainProgram
+	// explainQueryPlan explain query plan <stmt> 列出每张表的访问方式
+	explainQueryPlan
+)
+
+var opcodeNames = map[vmOpcode]string{
+	opHalt:        "Halt",
+	opGoto:        "Goto",
+	opTransaction: "Transaction",
+	opCreateTable: "CreateTable",
+	opAnalyze:     "Analyze",
+	opOpenRead:    "OpenRead",
+	opOpenWrite:   "OpenWrite",
+	opRewind:      "Rewind",
+	opNext:        "Next",
+	opSeekRowid:   "SeekRowid",
+	opSeekGe:      "SeekGE",
+	opHashBuild:   "HashBuild",
+	opHashProbe:   "HashProbe",
+	opHashNext:    "HashNext",
+	opNullRow:     "NullRow",
+	opColumn:      "Column",
+	opInteger:     "Integer",
+	opValue:       "Value",
+	opCopy:        "Copy",
+	opExpr:        "Expr",
+	opIf:          "If",
+	opIfNot:       "IfNot",
+	opEq:          "Eq",
+	opNe:          "Ne",
+	opLt:          "Lt",
+	opLe:          "Le",
+	opGt:          "Gt",
+	opGe:          "Ge",
+	opResultRow:   "ResultRow",
+	opAggStep:     "AggStep",
+	opAggFinal:    "AggFinal",
+	opInsert:      "Insert",
+}
+
+func (opcode vmOpcode) String() string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("Opcode%d", int(opcode))
+}
+
+var (
+	explainColumns   = []string{"addr", "opcode", "p1", "p2", "p3", "p4", "comment"}
+	queryPlanColumns = []string{"id", "parent", "detail"}
+)
+
+// @Explain explain [query plan] <stmt>
+func prepareExplain(input string, statement *sqlStatement) PrepareResult {
+	parser, ok := newParser(input)
+	if !ok || !parser.acceptKeyword("explain") {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	mode := explainProgram
+	if parser.acceptKeyword("query") {
+		if !parser.acceptKeyword("plan") {
+			return PREPARE_SYNTAX_ERROR
+		}
+		mode = explainQueryPlan
+	}
+	if parser.atEnd() || parser.isKeyword("explain") {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	result := parseStatement(parser.input[parser.peek().pos:], statement)
+	statement.explain = mode
+	return result
+}
+
+// explainColumns 返回explain结果的列名
+func (statement *sqlStatement) explainColumns() []string {
+	if statement.explain == explainQueryPlan {
+		return queryPlanColumns
+	}
+	return explainColumns
+}
+
+// explainRows 返回explain的结果行, 不执行语句
+func (statement *sqlStatement) explainRows() [][]Value {
+	program := statement.compiled()
+	if statement.explain == explainQueryPlan {
+		return program.queryPlan()
+	}
+
+	rows := make([][]Value, len(program.instructions))
+	for addr, in := range program.instructions {
+		rows[addr] = []Value{
+			integerValue(int64(addr)),
+			textValue(in.opcode.String()),
+			integerValue(int64(in.p1)),
+			integerValue(int64(in.p2)),
+			integerValue(int64(in.p3)),
+			textValue(p4String(in.p4)),
+			textValue(program.comment(in)),
+		}
+	}
+	return rows
+}
+
+// queryPlan 按循环嵌套的顺序列出每张表的访问方式, 和实际执行的指令一致
+func (program *vmProgram) queryPlan() [][]Value {
+	rows := make([][]Value, len(program.plan))
+	for i, step := range program.plan {
+		rows[i] = []Value{integerValue(int64(step.addr)), integerValue(0), textValue(step.detail)}
+	}
+	return rows
+}
+
+// comment 说明指令读写的寄存器
+func (program *vmProgram) comment(in instruction) string {
+	switch in.opcode {
+	case opColumn:
+		return fmt.Sprintf("r[%d]=%s.%s", in.p3, program.cursorAlias(in.p1), tableColumns[in.p2])
+	case opInteger, opValue, opCopy, opExpr:
+		value := p4String(in.p4)
+		switch in.opcode {
+		case opInteger:
+			value = fmt.Sprint(in.p1)
+		case opCopy:
+			value = fmt.Sprintf("r[%d]", in.p1)
+		}
+		return fmt.Sprintf("r[%d]=%s", in.p3, value)
+	case opEq, opNe, opLt, opLe, opGt, opGe:
+		return fmt.Sprintf("if not r[%d] %s r[%d] goto %d", in.p1, comparisonOperators[in.opcode], in.p3, in.p2)
+	case opResultRow:
+		return fmt.Sprintf("output r[%d..%d]", in.p1, in.p1+in.p2-1)
+	case opInsert:
+		return fmt.Sprintf("insert r[%d..%d]", in.p2, in.p2+len(tableColumns)-1)
+	}
+	return ""
+}
+
+var comparisonOperators = map[vmOpcode]string{
+	opEq: "=", opNe: "!=", opLt: "<", opLe: "<=", opGt: ">", opGe: ">=",
+}
+
+// cursorAlias 游标对应的表在语句中的名字, 有别名时使用别名
+func (program *vmProgram) cursorAlias(cursor int) string {
+	name := program.cursorNames[cursor]
+	if i := strings.LastIndex(name, " AS "); i >= 0 {
+		return name[i+len(" AS "):]
+	}
+	return name
+}
+
+func p4String(p4 interface{}) string {
+	switch p4 := p4.(type) {
+	case nil:
+		return ""
+	case string:
+		return p4
+	case Value:
+		return valueLiteral(p4)
+	case *exprNode:
+		return exprString(p4)
+	case *selectStatement:
+		aggregates := make([]string, len(p4.aggregates))
+		for i, expr := range p4.aggregates {
+			aggregates[i] = exprString(expr)
+		}
+		return strings.Join(aggregates, ", ")
+	}
+	return fmt.Sprint(p4)
+}
+
+// valueLiteral 文本加上引号, 与SQL中的写法相同
+func valueLiteral(value Value) string {
+	switch value.typ {
+	case valueNull:
+		return "NULL"
+	case valueText:
+		return quoteText(value.text)
+	}
+	return value.String()
+}
+
+// exprString 把表达式还原为SQL文本, 二元运算加上括号
+func exprString(expr *exprNode) string {
+	switch expr.typ {
+	case exprLiteral:
+		return valueLiteral(expr.value)
+	case exprParameter:
+		return fmt.Sprintf("?%d", expr.paramIndex)
+	case exprColumn:
+		if expr.table != "" {
+			return expr.table + "." + expr.column
+		}
+		return expr.column
+	case exprUnary:
+		if expr.op == "not" {
+			return "not " + exprString(expr.left)
+		}
+		return expr.op + exprString(expr.left)
+	case exprBinary:
+		return fmt.Sprintf("(%s %s %s)", exprString(expr.left), expr.op, exprString(expr.right))
+	case exprFunction, exprAggregate:
+		if expr.star {
+			return expr.name + "(*)"
+		}
+		args := make([]string, len(expr.args))
+		for i, arg := range expr.args {
+			args[i] = exprString(arg)
+		}
+		return fmt.Sprintf("%s(%s)", expr.name, strings.Join(args, ", "))
+	}
+	return "?"
+}
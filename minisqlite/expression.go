@@ -0,0 +1,693 @@
+package minisqlite
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type valueType int
+
+const (
+	valueNull valueType = iota
+	valueInteger
+	valueReal
+	valueText
+)
+
+type Value struct {
+	typ     valueType
+	integer int64
+	real    float64
+	text    string
+}
+
+func nullValue() Value {
+	return Value{typ: valueNull}
+}
+
+func integerValue(i int64) Value {
+	return Value{typ: valueInteger, integer: i}
+}
+
+func realValue(f float64) Value {
+	return Value{typ: valueReal, real: f}
+}
+
+func textValue(s string) Value {
+	return Value{typ: valueText, text: s}
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return integerValue(1)
+	}
+	return integerValue(0)
+}
+
+func (value Value) String() string {
+	switch value.typ {
+	case valueInteger:
+		return strconv.FormatInt(value.integer, 10)
+	case valueReal:
+		s := strconv.FormatFloat(value.real, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s
+	case valueText:
+		return value.text
+	default:
+		return "NULL"
+	}
+}
+
+// numeric 把值转换为数字, 文本按前缀数字解析, 无法解析时为0
+func (value Value) numeric() Value {
+	switch value.typ {
+	case valueInteger, valueReal, valueNull:
+		return value
+	}
+
+	s := strings.TrimSpace(value.text)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return integerValue(i)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return realValue(f)
+	}
+
+	end := 0
+	for end < len(s) && (isDigit(s[end]) || (end == 0 && (s[end] == '-' || s[end] == '+'))) {
+		end++
+	}
+	i, _ := strconv.ParseInt(s[:end], 10, 64)
+	return integerValue(i)
+}
+
+func (value Value) isNumeric() bool {
+	return value.typ == valueInteger || value.typ == valueReal
+}
+
+func (value Value) float() float64 {
+	if value.typ == valueReal {
+		return value.real
+	}
+	return float64(value.integer)
+}
+
+func isTruthy(value Value) bool {
+	value = value.numeric()
+	switch value.typ {
+	case valueInteger:
+		return value.integer != 0
+	case valueReal:
+		return value.real != 0
+	}
+	return false
+}
+
+// looksNumeric 文本是否是一个完整的数字
+func looksNumeric(s string) bool {
+	if _, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return true
+	}
+	return false
+}
+
+// compareValues 排序规则: NULL < 数字 < 文本; 数字和数字形式的文本按数值比较
+func compareValues(a, b Value) int {
+	if a.typ == valueText && b.isNumeric() && looksNumeric(a.text) {
+		a = a.numeric()
+	}
+	if b.typ == valueText && a.isNumeric() && looksNumeric(b.text) {
+		b = b.numeric()
+	}
+
+	rank := func(v Value) int {
+		switch v.typ {
+		case valueNull:
+			return 0
+		case valueInteger, valueReal:
+			return 1
+		}
+		return 2
+	}
+	if rank(a) != rank(b) {
+		return rank(a) - rank(b)
+	}
+
+	switch a.typ {
+	case valueNull:
+		return 0
+	case valueText:
+		return strings.Compare(a.text, b.text)
+	}
+
+	if a.typ == valueInteger && b.typ == valueInteger {
+		switch {
+		case a.integer < b.integer:
+			return -1
+		case a.integer > b.integer:
+			return 1
+		}
+		return 0
+	}
+	switch af, bf := a.float(), b.float(); {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+type exprType int
+
+const (
+	exprLiteral exprType = iota
+	exprColumn
+	exprUnary
+	exprBinary
+	exprFunction
+	exprAggregate
+	exprParameter
+)
+
+type exprNode struct {
+	typ exprType
+
+	// exprLiteral / exprParameter
+	value      Value
+	paramIndex int
+
+	// exprColumn
+	table       string
+	column      string
+	columnIndex int
+
+	// exprUnary / exprBinary
+	op    string
+	left  *exprNode
+	right *exprNode
+
+	// exprFunction / exprAggregate
+	name     string
+	args     []*exprNode
+	star     bool
+	aggIndex int
+}
+
+// 参数个数范围, -1表示不限
+var scalarFunctions = map[string][2]int{
+	"abs":      {1, 1},
+	"coalesce": {1, -1},
+	"instr":    {2, 2},
+	"length":   {1, 1},
+	"lower":    {1, 1},
+	"substr":   {2, 3},
+	"upper":    {1, 1},
+}
+
+var aggregateFunctions = map[string]bool{
+	"avg":   true,
+	"count": true,
+	"max":   true,
+	"min":   true,
+	"sum":   true,
+}
+
+// 不能作为列名或别名的关键字
+var reservedKeywords = map[string]bool{
+	"and": true, "as": true, "by": true, "from": true, "group": true,
+	"having": true, "inner": true, "join": true, "left": true, "not": true,
+	"null": true, "on": true, "or": true, "outer": true, "select": true,
+	"where": true,
+}
+
+func isReserved(token sqlToken) bool {
+	return token.typ == tokenIdentifier && reservedKeywords[strings.ToLower(token.text)]
+}
+
+// exprScope 记录解析表达式时允许出现的内容
+type exprScope struct {
+	allowAggregates bool
+	aggregates      *[]*exprNode
+	inAggregate     bool
+}
+
+func (parser *sqlParser) parseExpr(scope *exprScope) (*exprNode, bool) {
+	return parser.parseOr(scope)
+}
+
+func (parser *sqlParser) parseOr(scope *exprScope) (*exprNode, bool) {
+	left, ok := parser.parseAnd(scope)
+	for ok && parser.acceptKeyword("or") {
+		var right *exprNode
+		right, ok = parser.parseAnd(scope)
+		left = &exprNode{typ: exprBinary, op: "or", left: left, right: right}
+	}
+	return left, ok
+}
+
+func (parser *sqlParser) parseAnd(scope *exprScope) (*exprNode, bool) {
+	left, ok := parser.parseNot(scope)
+	for ok && parser.acceptKeyword("and") {
+		var right *exprNode
+		right, ok = parser.parseNot(scope)
+		left = &exprNode{typ: exprBinary, op: "and", left: left, right: right}
+	}
+	return left, ok
+}
+
+func (parser *sqlParser) parseNot(scope *exprScope) (*exprNode, bool) {
+	if parser.acceptKeyword("not") {
+		operand, ok := parser.parseNot(scope)
+		return &exprNode{typ: exprUnary, op: "not", left: operand}, ok
+	}
+	return parser.parseComparison(scope)
+}
+
+func (parser *sqlParser) parseComparison(scope *exprScope) (*exprNode, bool) {
+	left, ok := parser.parseAdditive(scope)
+	if !ok {
+		return nil, false
+	}
+
+	for _, op := range []string{"=", "==", "!=", "<>", "<=", ">=", "<", ">"} {
+		if parser.acceptOperator(op) {
+			right, ok := parser.parseAdditive(scope)
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			return &exprNode{typ: exprBinary, op: op, left: left, right: right}, ok
+		}
+	}
+	return left, true
+}
+
+func (parser *sqlParser) parseAdditive(scope *exprScope) (*exprNode, bool) {
+	left, ok := parser.parseMultiplicative(scope)
+	for ok {
+		op := parser.peek().text
+		if !parser.acceptOperator("+") && !parser.acceptOperator("-") && !parser.acceptOperator("||") {
+			break
+		}
+		var right *exprNode
+		right, ok = parser.parseMultiplicative(scope)
+		left = &exprNode{typ: exprBinary, op: op, left: left, right: right}
+	}
+	return left, ok
+}
+
+func (parser *sqlParser) parseMultiplicative(scope *exprScope) (*exprNode, bool) {
+	left, ok := parser.parseUnary(scope)
+	for ok {
+		op := parser.peek().text
+		if !parser.acceptOperator("*") && !parser.acceptOperator("/") && !parser.acceptOperator("%") {
+			break
+		}
+		var right *exprNode
+		right, ok = parser.parseUnary(scope)
+		left = &exprNode{typ: exprBinary, op: op, left: left, right: right}
+	}
+	return left, ok
+}
+
+func (parser *sqlParser) parseUnary(scope *exprScope) (*exprNode, bool) {
+	if parser.acceptOperator("-") {
+		operand, ok := parser.parseUnary(scope)
+		return &exprNode{typ: exprUnary, op: "-", left: operand}, ok
+	}
+	if parser.acceptOperator("+") {
+		return parser.parseUnary(scope)
+	}
+	return parser.parsePrimary(scope)
+}
+
+func (parser *sqlParser) parsePrimary(scope *exprScope) (*exprNode, bool) {
+	token := parser.peek()
+
+	switch token.typ {
+	case tokenNumber:
+		parser.next()
+		if i, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+			return &exprNode{typ: exprLiteral, value: integerValue(i)}, true
+		}
+		f, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, false
+		}
+		return &exprNode{typ: exprLiteral, value: realValue(f)}, true
+	case tokenString:
+		parser.next()
+		return &exprNode{typ: exprLiteral, value: textValue(token.text)}, true
+	case tokenParameter:
+		parser.next()
+		if parser.parameters == nil {
+			return nil, false
+		}
+		return parser.parameters.add(token.text)
+	case tokenOperator:
+		if !parser.acceptOperator("(") {
+			return nil, false
+		}
+		expr, ok := parser.parseExpr(scope)
+		if !ok || !parser.acceptOperator(")") {
+			return nil, false
+		}
+		return expr, true
+	case tokenIdentifier:
+		if parser.acceptKeyword("null") {
+			return &exprNode{typ: exprLiteral, value: nullValue()}, true
+		}
+		if isReserved(token) {
+			return nil, false
+		}
+		parser.next()
+
+		if parser.acceptOperator("(") {
+			return parser.parseFunction(strings.ToLower(token.text), scope)
+		}
+
+		expr := &exprNode{typ: exprColumn, column: token.text}
+		if parser.acceptOperator(".") {
+			column := parser.next()
+			if column.typ != tokenIdentifier {
+				return nil, false
+			}
+			expr.table = token.text
+			expr.column = column.text
+		}
+		return expr, true
+	}
+
+	return nil, false
+}
+
+func (parser *sqlParser) parseFunction(name string, scope *exprScope) (*exprNode, bool) {
+	expr := &exprNode{typ: exprFunction, name: name}
+
+	if aggregateFunctions[name] {
+		if !scope.allowAggregates || scope.inAggregate {
+			return nil, false
+		}
+		expr.typ = exprAggregate
+		expr.aggIndex = len(*scope.aggregates)
+		*scope.aggregates = append(*scope.aggregates, expr)
+	} else if _, ok := scalarFunctions[name]; !ok {
+		return nil, false
+	}
+
+	if name == "count" && parser.acceptOperator("*") {
+		expr.star = true
+		return expr, parser.acceptOperator(")")
+	}
+
+	argScope := *scope
+	argScope.inAggregate = argScope.inAggregate || expr.typ == exprAggregate
+	if !parser.isOperator(")") {
+		for {
+			arg, ok := parser.parseExpr(&argScope)
+			if !ok {
+				return nil, false
+			}
+			expr.args = append(expr.args, arg)
+			if !parser.acceptOperator(",") {
+				break
+			}
+		}
+	}
+	if !parser.acceptOperator(")") {
+		return nil, false
+	}
+
+	if expr.typ == exprAggregate {
+		return expr, len(expr.args) == 1
+	}
+	arity := scalarFunctions[name]
+	if len(expr.args) < arity[0] || (arity[1] >= 0 && len(expr.args) > arity[1]) {
+		return nil, false
+	}
+	return expr, true
+}
+
+// walkExpr 先序遍历表达式树, fn返回false时停止遍历
+func walkExpr(expr *exprNode, fn func(*exprNode) bool) bool {
+	if expr == nil {
+		return true
+	}
+	if !fn(expr) {
+		return false
+	}
+	if !walkExpr(expr.left, fn) || !walkExpr(expr.right, fn) {
+		return false
+	}
+	for _, arg := range expr.args {
+		if !walkExpr(arg, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConstantExpr 表达式中没有列引用和聚合函数
+func isConstantExpr(expr *exprNode) bool {
+	return walkExpr(expr, func(e *exprNode) bool {
+		return e.typ != exprColumn && e.typ != exprAggregate
+	})
+}
+
+// evalExpr 对一行数据求值, aggregates为当前分组的聚合结果
+func evalExpr(expr *exprNode, row []Value, aggregates []Value) Value {
+	switch expr.typ {
+	case exprLiteral, exprParameter:
+		return expr.value
+	case exprColumn:
+		return row[expr.columnIndex]
+	case exprAggregate:
+		return aggregates[expr.aggIndex]
+	case exprUnary:
+		operand := evalExpr(expr.left, row, aggregates)
+		if operand.typ == valueNull {
+			return operand
+		}
+		if expr.op == "not" {
+			return boolValue(!isTruthy(operand))
+		}
+		return negate(operand.numeric())
+	case exprBinary:
+		return evalBinary(expr, row, aggregates)
+	case exprFunction:
+		args := make([]Value, len(expr.args))
+		for i, arg := range expr.args {
+			args[i] = evalExpr(arg, row, aggregates)
+		}
+		return callScalarFunction(expr.name, args)
+	}
+	return nullValue()
+}
+
+func evalBinary(expr *exprNode, row []Value, aggregates []Value) Value {
+	left := evalExpr(expr.left, row, aggregates)
+
+	// three-valued logic: NULL AND false is false, NULL OR true is true
+	switch expr.op {
+	case "and":
+		if left.typ != valueNull && !isTruthy(left) {
+			return boolValue(false)
+		}
+		right := evalExpr(expr.right, row, aggregates)
+		if right.typ != valueNull && !isTruthy(right) {
+			return boolValue(false)
+		}
+		if left.typ == valueNull || right.typ == valueNull {
+			return nullValue()
+		}
+		return boolValue(true)
+	case "or":
+		if left.typ != valueNull && isTruthy(left) {
+			return boolValue(true)
+		}
+		right := evalExpr(expr.right, row, aggregates)
+		if right.typ != valueNull && isTruthy(right) {
+			return boolValue(true)
+		}
+		if left.typ == valueNull || right.typ == valueNull {
+			return nullValue()
+		}
+		return boolValue(false)
+	}
+
+	right := evalExpr(expr.right, row, aggregates)
+	if left.typ == valueNull || right.typ == valueNull {
+		return nullValue()
+	}
+
+	switch expr.op {
+	case "=":
+		return boolValue(compareValues(left, right) == 0)
+	case "!=":
+		return boolValue(compareValues(left, right) != 0)
+	case "<":
+		return boolValue(compareValues(left, right) < 0)
+	case "<=":
+		return boolValue(compareValues(left, right) <= 0)
+	case ">":
+		return boolValue(compareValues(left, right) > 0)
+	case ">=":
+		return boolValue(compareValues(left, right) >= 0)
+	case "||":
+		return textValue(left.String() + right.String())
+	}
+
+	return evalArithmetic(expr.op, left.numeric(), right.numeric())
+}
+
+func evalArithmetic(op string, left, right Value) Value {
+	if left.typ == valueInteger && right.typ == valueInteger {
+		if result, ok := integerArithmetic(op, left.integer, right.integer); ok {
+			return result
+		}
+	}
+
+	// 整数溢出时与SQLite一样改为浮点数计算
+	a, b := left.float(), right.float()
+	switch op {
+	case "+":
+		return realValue(a + b)
+	case "-":
+		return realValue(a - b)
+	case "*":
+		return realValue(a * b)
+	case "/":
+		if b == 0 {
+			return nullValue()
+		}
+		return realValue(a / b)
+	case "%":
+		if b == 0 {
+			return nullValue()
+		}
+		return realValue(math.Mod(a, b))
+	}
+	return nullValue()
+}
+
+// integerArithmetic 结果超出int64时ok为false
+func integerArithmetic(op string, a, b int64) (result Value, ok bool) {
+	switch op {
+	case "+":
+		if sum := a + b; (sum > a) == (b > 0) {
+			return integerValue(sum), true
+		}
+		return Value{}, false
+	case "-":
+		if difference := a - b; (difference < a) == (b > 0) {
+			return integerValue(difference), true
+		}
+		return Value{}, false
+	case "*":
+		if a == 0 || b == 0 {
+			return integerValue(0), true
+		}
+		product := a * b
+		if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return Value{}, false
+		}
+		return integerValue(product), true
+	case "/":
+		if b == 0 {
+			return nullValue(), true
+		}
+		if a == math.MinInt64 && b == -1 {
+			return Value{}, false
+		}
+		return integerValue(a / b), true
+	case "%":
+		if b == 0 {
+			return nullValue(), true
+		}
+		return integerValue(a % b), true
+	}
+	return nullValue(), true
+}
+
+// negate -math.MinInt64 超出int64, 结果为浮点数
+func negate(v Value) Value {
+	if v.typ == valueReal || v.integer == math.MinInt64 {
+		return realValue(-v.float())
+	}
+	return integerValue(-v.integer)
+}
+
+func callScalarFunction(name string, args []Value) Value {
+	if name == "coalesce" {
+		for _, arg := range args {
+			if arg.typ != valueNull {
+				return arg
+			}
+		}
+		return nullValue()
+	}
+
+	for _, arg := range args {
+		if arg.typ == valueNull {
+			return nullValue()
+		}
+	}
+
+	switch name {
+	case "abs":
+		v := args[0].numeric()
+		if v.typ == valueReal {
+			return realValue(math.Abs(v.real))
+		}
+		if v.integer < 0 {
+			return negate(v)
+		}
+		return v
+	case "length":
+		// length, instr 和 substr 按字符计算, 与SQLite相同
+		return integerValue(int64(utf8.RuneCountInString(args[0].String())))
+	case "lower":
+		return textValue(strings.ToLower(args[0].String()))
+	case "upper":
+		return textValue(strings.ToUpper(args[0].String()))
+	case "instr":
+		s := args[0].String()
+		i := strings.Index(s, args[1].String())
+		if i < 0 {
+			return integerValue(0)
+		}
+		return integerValue(int64(utf8.RuneCountInString(s[:i]) + 1))
+	case "substr":
+		s := []rune(args[0].String())
+		// substr的起始位置从1开始
+		start := args[1].numeric().integer - 1
+		if start < 0 {
+			start = 0
+		}
+		if start > int64(len(s)) {
+			start = int64(len(s))
+		}
+		end := int64(len(s))
+		if len(args) == 3 {
+			length := args[2].numeric().integer
+			if length < 0 {
+				length = 0
+			}
+			// 比较剩余长度而不是start+length, length很大时相加会溢出
+			if length < end-start {
+				end = start + length
+			}
+		}
+		return textValue(string(s[start:end]))
+	}
+
+	return nullValue()
+}
@@ -0,0 +1,170 @@
+package minisqlite
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ImportError 导入时被跳过的一行, Line 为该行在CSV中开始的行号
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// Import 把CSV中的每一行插入到表中, table为空时导入users表.
+// 第一行的字段都是列名时作为表头, 按表头对应列, 否则按id, username, email的顺序.
+// 所有行在一个事务中插入, 单独一行的错误记录在返回的ImportError中, 不影响其他行;
+// 读文件或写数据库失败时回滚整个导入并返回error.
+func (db *DB) Import(r io.Reader, table string) (int, []*ImportError, error) {
+	if db.table == nil {
+		return 0, nil, errors.New("database is closed")
+	}
+	if table == "" {
+		table = defaultTableName
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	// 已经在事务中时插入到当前事务, 由调用方提交
+	started := true
+	err := executeStatement(&sqlStatement{typ: statementBegin}, db.table)
+	if err == EXECUTE_TRANSACTION_ACTIVE {
+		started = false
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	imported, rowErrors, err := db.importRows(reader, table)
+	if err == nil && started {
+		err = executeStatement(&sqlStatement{typ: statementCommit}, db.table)
+	}
+	if err != nil {
+		if started {
+			executeStatement(&sqlStatement{typ: statementRollback}, db.table)
+		}
+		return 0, nil, err
+	}
+	return imported, rowErrors, nil
+}
+
+func (db *DB) importRows(reader *csv.Reader, table string) (int, []*ImportError, error) {
+	var rowErrors []*ImportError
+	imported := 0
+	// columns[i] 为tableColumns[i]在记录中的下标, -1表示没有这一列
+	var columns []int
+	numFields := len(tableColumns)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, &ImportError{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrIO, err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		if columns == nil {
+			columns = headerColumns(record)
+			if columns != nil {
+				if columns[0] < 0 {
+					return 0, nil, fmt.Errorf("line %d: header has no id column", line)
+				}
+				numFields = len(record)
+				continue
+			}
+			columns = []int{0, 1, 2}
+		}
+
+		if len(record) != numFields {
+			rowErrors = append(rowErrors, &ImportError{Line: line, Err: fmt.Errorf("expected %d fields, got %d", numFields, len(record))})
+			continue
+		}
+
+		err = db.importRecord(record, columns, table)
+		if isRowError(err) {
+			rowErrors = append(rowErrors, &ImportError{Line: line, Err: err})
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		imported++
+	}
+	return imported, rowErrors, nil
+}
+
+// headerColumns 记录的每个字段都是不重复的列名时返回对应关系, 否则返回nil
+func headerColumns(record []string) []int {
+	columns := make([]int, len(tableColumns))
+	for i := range columns {
+		columns[i] = -1
+	}
+	for i, field := range record {
+		found := false
+		for j, column := range tableColumns {
+			if strings.EqualFold(strings.TrimSpace(field), column) && columns[j] < 0 {
+				columns[j] = i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return columns
+}
+
+func (db *DB) importRecord(record []string, columns []int, table string) error {
+	fields := make([]string, len(tableColumns))
+	for i, index := range columns {
+		if index >= 0 {
+			fields[i] = record[index]
+		}
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+	if err != nil {
+		return EXECUTE_TYPE_MISMATCH
+	}
+	statement := &sqlStatement{typ: statementInsert, tableName: table}
+	if err := prepareError(fillRowToInsert(statement, id, fields[1], fields[2])); err != nil {
+		return err
+	}
+	return executeStatement(statement, db.table)
+}
+
+// isRowError 只影响这一行的错误, 其余错误中止导入
+func isRowError(err error) bool {
+	if errors.As(err, new(PrepareResult)) {
+		return true
+	}
+	var result ExecuteResult
+	if !errors.As(err, &result) {
+		return false
+	}
+	switch result {
+	case EXECUTE_DUPLICATE_KEY, EXECUTE_TABLE_FULL, EXECUTE_NEGATIVE_ID, EXECUTE_STRING_TOO_LONG, EXECUTE_TYPE_MISMATCH:
+		return true
+	}
+	return false
+}
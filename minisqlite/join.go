@@ -0,0 +1,97 @@
+package minisqlite
+
+import (
+	"math"
+)
+
+// exprTables 返回表达式引用的表的下标集合, 含聚合函数时ok为false
+func exprTables(expr *exprNode) (tables map[int]bool, ok bool) {
+	tables = make(map[int]bool)
+	ok = walkExpr(expr, func(e *exprNode) bool {
+		if e.typ == exprColumn {
+			tables[e.columnIndex/len(tableColumns)] = true
+		}
+		return e.typ != exprAggregate
+	})
+	return tables, ok
+}
+
+func onlyTable(expr *exprNode, level int) bool {
+	tables, ok := exprTables(expr)
+	return ok && len(tables) == 1 && tables[level]
+}
+
+// conjuncts 把 a AND b AND c 拆成 [a b c]
+func conjuncts(expr *exprNode, out []*exprNode) []*exprNode {
+	if expr == nil {
+		return out
+	}
+	if expr.typ == exprBinary && expr.op == "and" {
+		out = conjuncts(expr.left, out)
+		return conjuncts(expr.right, out)
+	}
+	return append(out, expr)
+}
+
+// buildHash 扫描内表, 按连接键建立哈希表. 连接键只引用内表的列, 求值时内表的列位于连接行的offset处
+func buildHash(table *btree, key *exprNode, offset int) (map[string][][]Value, error) {
+	hash := make(map[string][][]Value)
+	scratch := make([]Value, offset+len(tableColumns))
+
+	var row tableRow
+	cursor, err := tableStart(table)
+	if err != nil {
+		return nil, err
+	}
+	for !cursor.endOfTable {
+		if err := cursor.cursorRow(&row); err != nil {
+			return nil, err
+		}
+		values := rowValues(&row)
+		copy(scratch[offset:], values)
+
+		if value := evalExpr(key, scratch, nil); value.typ != valueNull {
+			hash[hashKey(value)] = append(hash[hashKey(value)], values)
+		}
+		if err := cursor.cursorAdvance(); err != nil {
+			return nil, err
+		}
+	}
+	return hash, nil
+}
+
+// hashKey 相等的值得到相同的键, 数字形式的文本按数值处理
+func hashKey(value Value) string {
+	if value.typ == valueText && looksNumeric(value.text) {
+		value = value.numeric()
+	}
+	return groupKey(value)
+}
+
+// groupKey 数值相等的INTEGER和REAL得到相同的键, 文本不转换,
+// 与compareValues一致: '1', '01', '1.0' 是不同的值
+func groupKey(value Value) string {
+	if value.typ == valueReal && value.real == math.Trunc(value.real) && math.Abs(value.real) < 1<<63 {
+		value = integerValue(int64(value.real))
+	}
+	return string(appendValue(nil, value))
+}
+
+// tableLookup 用tableFind按主键查找一行
+func tableLookup(table *btree, key uint32, row *tableRow) (bool, error) {
+	cursor, err := tableFind(table, key)
+	if err != nil {
+		return false, err
+	}
+	node, err := table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return false, err
+	}
+	if cursor.cellNum >= *(*uint32)(leafNodeNumCells(node)) {
+		return false, nil
+	}
+	if *(*uint32)(leafNodeKey(node, cursor.cellNum)) != key {
+		return false, nil
+	}
+	return true, cursor.cursorRow(row)
+}
@@ -0,0 +1,310 @@
+package minisqlite
+
+import (
+	"math"
+	"strings"
+)
+
+// accessType 查询计划中访问一张表的方式.
+//
+// 数据库不支持create index, 除了按主键组织的B树之外没有持久的索引,
+// 所以索引访问路径只有主键查找和范围扫描, 以及每次查询临时建立的自动哈希索引.
+// analyze统计的每列不同值个数用于估计等值条件的选择性, 也就是自动哈希索引每个键的行数.
+// 有了二级索引之后再在这里加入对应的访问方式
+type accessType int
+
+const (
+	// accessScan 从第一行开始扫描整张表
+	accessScan accessType = iota
+	// accessRowidEq id = expr, 用tableFind查找一行
+	accessRowidEq
+	// accessRowidRange id > / >= / < / <= expr, 用tableFind定位到下界后顺序扫描到上界
+	accessRowidRange
+	// accessHash 内表表达式 = 外层表达式, 扫描内表建立自动哈希索引后按键查找
+	accessHash
+)
+
+const (
+	// 没有analyze统计时假设的表行数和每个值重复的行数
+	defaultRowEstimate  = 1000
+	defaultRowsPerValue = 10
+	// rangeSelectivity 一个范围条件保留的行数比例
+	rangeSelectivity = 0.25
+	// hashBuildCost 建立哈希索引时每行的代价, 相对顺序读取一行
+	hashBuildCost = 2
+	// maxReorderTables 超过这个数量的表按FROM中的顺序连接, 不枚举连接顺序
+	maxReorderTables = 6
+)
+
+// accessPath 连接中的一层循环, 以某种方式访问FROM中的第table张表
+type accessPath struct {
+	typ   accessType
+	table int
+
+	// accessRowidEq 的主键; accessRowidRange 的下界和上界, 没有时为nil
+	key     *exprNode
+	lower   *exprNode
+	lowerOp string
+	upper   *exprNode
+	upperOp string
+
+	// accessHash 只引用内表的键和只引用外层表的键
+	hashInner *exprNode
+	hashOuter *exprNode
+
+	// filters 在这一层求值的条件, 用于访问的条件也包括在内.
+	// LEFT JOIN 的表为它的ON条件, 决定是否需要补NULL行
+	filters []*exprNode
+}
+
+// selectPlan 按循环嵌套顺序排列的访问方式
+type selectPlan struct {
+	loops []accessPath
+	// filters 所有循环之后求值的条件, 它们引用了LEFT JOIN中可能补NULL的表
+	filters []*exprNode
+	cost    float64
+}
+
+// planTerm WHERE 和内连接ON中的一个AND条件, 可以在它引用的表都已经访问后的任意一层求值
+type planTerm struct {
+	expr   *exprNode
+	tables map[int]bool
+	// padded 引用了LEFT JOIN的表, 只能在所有循环之后求值
+	padded bool
+}
+
+type planner struct {
+	sel   *selectStatement
+	stats map[string]*tableStatistics
+	terms []planTerm
+	// onTerms LEFT JOIN 的表的ON条件, 只能在这张表所在的层求值
+	onTerms [][]*exprNode
+}
+
+// planSelect 枚举连接顺序和每张表的访问方式, 按估计的代价选择最便宜的计划.
+// stats 为analyze收集的统计, 没有统计的表使用默认的估计
+func planSelect(sel *selectStatement, stats map[string]*tableStatistics) {
+	p := &planner{sel: sel, stats: stats, onTerms: make([][]*exprNode, len(sel.from))}
+
+	padded := make(map[int]bool)
+	hasLeftJoin := false
+	for k, ref := range sel.from {
+		if ref.joinType == joinLeft {
+			padded[k] = true
+			hasLeftJoin = true
+		}
+	}
+	addTerms := func(expr *exprNode) {
+		for _, term := range conjuncts(expr, nil) {
+			tables, _ := exprTables(term)
+			isPadded := false
+			for k := range tables {
+				isPadded = isPadded || padded[k]
+			}
+			p.terms = append(p.terms, planTerm{expr: term, tables: tables, padded: isPadded})
+		}
+	}
+	for k, ref := range sel.from {
+		if ref.joinType == joinLeft {
+			p.onTerms[k] = conjuncts(ref.on, nil)
+		} else {
+			addTerms(ref.on)
+		}
+	}
+	addTerms(sel.where)
+
+	// LEFT JOIN 改变连接顺序会改变结果, 只在全部是内连接时枚举顺序
+	order := make([]int, len(sel.from))
+	for k := range order {
+		order[k] = k
+	}
+	if hasLeftJoin || len(order) > maxReorderTables {
+		sel.plan = p.planOrder(order)
+		return
+	}
+
+	var best *selectPlan
+	permute(order, 0, func(order []int) {
+		if plan := p.planOrder(order); best == nil || plan.cost < best.cost {
+			best = plan
+		}
+	})
+	sel.plan = best
+}
+
+// permute 依次以每种排列调用fn, 第一个排列为原来的顺序
+func permute(order []int, k int, fn func([]int)) {
+	if k == len(order) {
+		fn(order)
+		return
+	}
+	for i := k; i < len(order); i++ {
+		order[k], order[i] = order[i], order[k]
+		permute(order, k+1, fn)
+		order[k], order[i] = order[i], order[k]
+	}
+}
+
+// planOrder 按给定的连接顺序为每层选择代价最小的访问方式, 并把条件放到最早可以求值的一层
+func (p *planner) planOrder(order []int) *selectPlan {
+	plan := &selectPlan{}
+	placed := make([]bool, len(p.terms))
+	bound := make(map[int]bool)
+	outerRows := 1.0
+
+	for _, table := range order {
+		bound[table] = true
+
+		// 内连接的表使用所有引用的表都已经访问的条件, LEFT JOIN 的表只使用自己的ON条件
+		var filters []*exprNode
+		if p.sel.from[table].joinType == joinLeft {
+			filters = p.onTerms[table]
+		} else {
+			for i, term := range p.terms {
+				if !placed[i] && !term.padded && subset(term.tables, bound) {
+					placed[i] = true
+					filters = append(filters, term.expr)
+				}
+			}
+		}
+
+		path, cost := p.bestPath(table, filters, bound, outerRows)
+		path.filters = filters
+		plan.loops = append(plan.loops, path)
+		plan.cost += cost
+
+		rows := p.tableRows(table)
+		for _, expr := range filters {
+			rows *= p.selectivity(table, expr)
+		}
+		if p.sel.from[table].joinType == joinLeft {
+			rows = math.Max(rows, 1)
+		}
+		outerRows *= math.Max(rows, 1)
+	}
+
+	for i, term := range p.terms {
+		if !placed[i] {
+			plan.filters = append(plan.filters, term.expr)
+		}
+	}
+	return plan
+}
+
+// bestPath 返回访问table代价最小的方式和总代价. 外层的每一行都要执行一次循环,
+// 哈希索引只需要建立一次
+func (p *planner) bestPath(table int, filters []*exprNode, bound map[int]bool, outerRows float64) (accessPath, float64) {
+	rows := p.tableRows(table)
+	seekCost := math.Log2(rows+1) + 1
+
+	best, bestCost := accessPath{typ: accessScan, table: table}, outerRows*rows
+	consider := func(path accessPath, cost, build float64) {
+		if total := outerRows*cost + build; total < bestCost {
+			best, bestCost = path, total
+		}
+	}
+
+	rangePath := accessPath{typ: accessRowidRange, table: table}
+	idColumn := table * len(tableColumns)
+	outer := func(expr *exprNode) bool {
+		tables, ok := exprTables(expr)
+		return ok && !tables[table] && subset(tables, bound)
+	}
+
+	for _, term := range filters {
+		if term.typ != exprBinary {
+			continue
+		}
+		for _, pair := range [][2]*exprNode{{term.left, term.right}, {term.right, term.left}} {
+			inner, other := pair[0], pair[1]
+			op := term.op
+			if inner == term.right {
+				op = flippedComparison[op]
+			}
+			if !outer(other) {
+				continue
+			}
+
+			isID := inner.typ == exprColumn && inner.columnIndex == idColumn
+			switch {
+			case isID && op == "=":
+				consider(accessPath{typ: accessRowidEq, table: table, key: other}, seekCost, 0)
+			case isID && (op == ">" || op == ">=") && rangePath.lower == nil:
+				rangePath.lower, rangePath.lowerOp = other, op
+			case isID && (op == "<" || op == "<=") && rangePath.upper == nil:
+				rangePath.upper, rangePath.upperOp = other, op
+			case op == "=" && onlyTable(inner, table):
+				path := accessPath{typ: accessHash, table: table, hashInner: inner, hashOuter: other}
+				consider(path, 1+rows*p.selectivity(table, term), hashBuildCost*rows)
+			}
+		}
+	}
+
+	if rangePath.lower != nil || rangePath.upper != nil {
+		fraction := 1.0
+		if rangePath.lower != nil {
+			fraction *= rangeSelectivity
+		}
+		if rangePath.upper != nil {
+			fraction *= rangeSelectivity
+		}
+		consider(rangePath, seekCost+rows*fraction, 0)
+	}
+	return best, bestCost
+}
+
+var flippedComparison = map[string]string{
+	"=": "=", "!=": "!=", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// tableRows 表的行数, 没有统计时使用默认值
+func (p *planner) tableRows(table int) float64 {
+	if stats := p.tableStats(table); stats != nil {
+		return math.Max(stats.rows, 1)
+	}
+	return defaultRowEstimate
+}
+
+func (p *planner) tableStats(table int) *tableStatistics {
+	return p.stats[strings.ToLower(p.sel.from[table].name)]
+}
+
+// selectivity 条件对table保留的行数比例: 列的等值条件为1/不同值的个数, 范围条件为rangeSelectivity
+func (p *planner) selectivity(table int, expr *exprNode) float64 {
+	if expr.typ != exprBinary {
+		return 1
+	}
+	switch expr.op {
+	case "=":
+		for _, side := range []*exprNode{expr.left, expr.right} {
+			if side.typ == exprColumn && side.columnIndex/len(tableColumns) == table {
+				return 1 / p.distinctValues(table, side.columnIndex%len(tableColumns))
+			}
+		}
+		return 1.0 / defaultRowsPerValue
+	case "<", "<=", ">", ">=":
+		return rangeSelectivity
+	}
+	return 1
+}
+
+// distinctValues 列中不同值的个数, id 是主键, 每行都不同
+func (p *planner) distinctValues(table, column int) float64 {
+	rows := p.tableRows(table)
+	if column == 0 {
+		return rows
+	}
+	if stats := p.tableStats(table); stats != nil && column < len(stats.distinct) {
+		return math.Max(stats.distinct[column], 1)
+	}
+	return math.Max(rows/defaultRowsPerValue, 1)
+}
+
+func subset(tables, bound map[int]bool) bool {
+	for k := range tables {
+		if !bound[k] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package minisqlite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const maxParameterIndex = 999
+
+// parameterList 语句中的占位符, 参数下标从1开始
+type parameterList struct {
+	exprs []*exprNode
+	// names[i-1] 为第i个参数的名字, ? 形式的参数没有名字
+	names []string
+}
+
+// add 按SQLite的规则分配下标: ? 取下一个下标, ?NNN 使用NNN, 同名的 :name 共用一个下标
+func (list *parameterList) add(text string) (*exprNode, bool) {
+	index := 0
+	switch {
+	case text == "?":
+		index = len(list.names) + 1
+	case text[0] == '?':
+		n, err := strconv.Atoi(text[1:])
+		if err != nil || n < 1 {
+			return nil, false
+		}
+		index = n
+	default:
+		for i, name := range list.names {
+			if name == text {
+				index = i + 1
+			}
+		}
+		if index == 0 {
+			index = len(list.names) + 1
+		}
+	}
+	if index > maxParameterIndex {
+		return nil, false
+	}
+
+	for len(list.names) < index {
+		list.names = append(list.names, "")
+	}
+	if text[0] == ':' {
+		list.names[index-1] = text
+	}
+
+	expr := &exprNode{typ: exprParameter, paramIndex: index}
+	list.exprs = append(list.exprs, expr)
+	return expr, true
+}
+
+func (list *parameterList) count() int {
+	return len(list.names)
+}
+
+func (list *parameterList) set(index int, value Value) {
+	for _, expr := range list.exprs {
+		if expr.paramIndex == index {
+			expr.value = value
+		}
+	}
+}
+
+func isParameter(field string) bool {
+	return strings.HasPrefix(field, "?") || (strings.HasPrefix(field, ":") && len(field) > 1)
+}
+
+// preparedStatement 只解析一次, 绑定参数后可以反复执行
+type preparedStatement struct {
+	table     *btree
+	statement sqlStatement
+}
+
+func prepare(table *btree, sql string) (*preparedStatement, PrepareResult) {
+	stmt := &preparedStatement{table: table}
+	if result := prepareStatement(sql, &stmt.statement); result != PREPARE_SUCCESS {
+		return nil, result
+	}
+	return stmt, PREPARE_SUCCESS
+}
+
+// replan 有analyze收集的统计时按统计重新选择查询计划
+func (stmt *preparedStatement) replan(stats map[string]*tableStatistics) {
+	if sel := stmt.statement.sel; sel != nil && len(stats) > 0 {
+		planSelect(sel, stats)
+		stmt.statement.program = compileStatement(&stmt.statement)
+	}
+}
+
+func (stmt *preparedStatement) parameterCount() int {
+	return stmt.statement.parameters.count()
+}
+
+// parameterName 返回第index个参数的名字(包含前缀:), ? 参数返回空字符串
+func (stmt *preparedStatement) parameterName(index int) string {
+	if index < 1 || index > stmt.parameterCount() {
+		return ""
+	}
+	return stmt.statement.parameters.names[index-1]
+}
+
+// parameterIndex 按名字查找参数下标, 名字可以省略前缀:, 找不到时返回0
+func (stmt *preparedStatement) parameterIndex(name string) int {
+	if !strings.HasPrefix(name, ":") {
+		name = ":" + name
+	}
+	for i, parameterName := range stmt.statement.parameters.names {
+		if parameterName == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (stmt *preparedStatement) bind(index int, value interface{}) error {
+	if index < 1 || index > stmt.parameterCount() {
+		return fmt.Errorf("bind index %d out of range 1..%d", index, stmt.parameterCount())
+	}
+
+	converted, err := toValue(value)
+	if err != nil {
+		return err
+	}
+	stmt.statement.parameters.set(index, converted)
+	return nil
+}
+
+func (stmt *preparedStatement) bindNamed(name string, value interface{}) error {
+	index := stmt.parameterIndex(name)
+	if index == 0 {
+		return fmt.Errorf("no such parameter %s", name)
+	}
+	return stmt.bind(index, value)
+}
+
+// reset 把所有参数恢复为NULL, 之后可以重新绑定并执行
+func (stmt *preparedStatement) reset() {
+	for i := 1; i <= stmt.parameterCount(); i++ {
+		stmt.statement.parameters.set(i, nullValue())
+	}
+}
+
+func (stmt *preparedStatement) execute() error {
+	return executeStatement(&stmt.statement, stmt.table)
+}
+
+// toValue 把Go的值转换为Value
+func toValue(value interface{}) (Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return nullValue(), nil
+	case Value:
+		return v, nil
+	case bool:
+		return boolValue(v), nil
+	case int:
+		return integerValue(int64(v)), nil
+	case int8:
+		return integerValue(int64(v)), nil
+	case int16:
+		return integerValue(int64(v)), nil
+	case int32:
+		return integerValue(int64(v)), nil
+	case int64:
+		return integerValue(v), nil
+	case uint:
+		return uintValue(uint64(v))
+	case uint8:
+		return integerValue(int64(v)), nil
+	case uint16:
+		return integerValue(int64(v)), nil
+	case uint32:
+		return integerValue(int64(v)), nil
+	case uint64:
+		return uintValue(v)
+	case float32:
+		return realValue(float64(v)), nil
+	case float64:
+		return realValue(v), nil
+	case string:
+		return textValue(v), nil
+	case []byte:
+		return textValue(string(v)), nil
+	}
+	return Value{}, fmt.Errorf("unsupported type %T", value)
+}
+
+func uintValue(v uint64) (Value, error) {
+	if v > math.MaxInt64 {
+		return Value{}, fmt.Errorf("integer %d overflows int64", v)
+	}
+	return integerValue(int64(v)), nil
+}
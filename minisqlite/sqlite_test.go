@@ -0,0 +1,1859 @@
+package minisqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestInsert(t *testing.T) {
+	fileName := "mydb.db"
+	table, err := dbOpen(fileName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 34; i++ {
+		var statement sqlStatement
+		statement.typ = statementInsert
+		statement.rowToInsert.id = uint32(i)
+		copy(statement.rowToInsert.username[:], []byte(fmt.Sprintf("user%d", i)))
+		copy(statement.rowToInsert.email[:], []byte(fmt.Sprintf("person%d@qq.com", i)))
+
+		executeStatement(&statement, table)
+	}
+
+	if err := table.dbClose(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openTable 打开数据库, 测试结束时关闭
+func openTable(t *testing.T, path string) *btree {
+	t.Helper()
+
+	table, err := dbOpen(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { table.dbClose() })
+	return table
+}
+
+func runStatement(t *testing.T, table *btree, input string) {
+	t.Helper()
+
+	var statement sqlStatement
+	if result := prepareStatement(input, &statement); result != PREPARE_SUCCESS {
+		t.Fatalf("prepare %q: result %d", input, result)
+	}
+	if err := executeStatement(&statement, table); err != nil {
+		t.Fatalf("execute %q: %v", input, err)
+	}
+}
+
+// formatRows 按REPL的格式输出每一行 (v1, v2)
+func formatRows(values [][]Value) string {
+	var output strings.Builder
+	for _, row := range values {
+		fields := make([]string, len(row))
+		for i, value := range row {
+			fields[i] = value.String()
+		}
+		fmt.Fprintf(&output, "(%s)\n", strings.Join(fields, ", "))
+	}
+	return output.String()
+}
+
+// queryOutput 执行查询, 返回格式化后的结果
+func queryOutput(t *testing.T, table *btree, input string) string {
+	t.Helper()
+
+	var statement sqlStatement
+	if result := prepareStatement(input, &statement); result != PREPARE_SUCCESS {
+		t.Fatalf("prepare %q: result %d", input, result)
+	}
+	var values [][]Value
+	if err := querySelect(&statement, table, func(row []Value) { values = append(values, row) }); err != nil {
+		t.Fatalf("execute %q: %v", input, err)
+	}
+	return formatRows(values)
+}
+
+// TestInsertMultiLine REPL中跨行输入的insert, 字段之间可以是任意空白
+func TestInsertMultiLine(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	runStatement(t, table, "insert 1\n  user1\tperson1@qq.com")
+	runStatement(t, table, "insert into\nusers values (2,\n'user2', 'person2@qq.com')")
+	if output := queryOutput(t, table, "select * from users"); output != "(1, user1, person1@qq.com)\n(2, user2, person2@qq.com)\n" {
+		t.Fatalf("unexpected rows:\n%s", output)
+	}
+}
+
+func TestInsertIntoRejects(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	runStatement(t, table, "insert into users values ('7', 'user7', 'user7@a.com')")
+	tests := []string{
+		"insert into users values ('abc', 'user1', 'a@b')",
+		"insert into users values ('7zz', 'user1', 'a@b')",
+		"insert into users values (1.5, 'user1', 'a@b')",
+		"insert into users values (null, 'user1', 'a@b')",
+		"insert into users values (1, null, 'a@b')",
+		"insert into users values (1, 'user1', null)",
+	}
+	for _, input := range tests {
+		var statement sqlStatement
+		if result := prepareStatement(input, &statement); result != PREPARE_SYNTAX_ERROR {
+			t.Errorf("%q: expected syntax error, got %d", input, result)
+		}
+	}
+	if output := queryOutput(t, table, "select * from users"); output != "(7, user7, user7@a.com)\n" {
+		t.Fatalf("unexpected rows %q", output)
+	}
+}
+
+func TestGroupByHaving(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	domains := []string{"a.com", "b.com", "a.com", "c.com", "a.com", "c.com", "d.com"}
+	for i, domain := range domains {
+		runStatement(t, table, fmt.Sprintf("insert %d user%d user%d@%s", i+1, i+1, i+1, domain))
+	}
+
+	query := "select substr(email, instr(email, '@') + 1) as domain, count(*), min(id) from users group by domain having count(*) > 1"
+	output := queryOutput(t, table, query)
+	expected := "(a.com, 3, 1)\n(c.com, 2, 4)\n"
+	if output != expected {
+		t.Fatalf("expected %q, got %q", expected, output)
+	}
+
+	output = queryOutput(t, table, "select count(*), sum(id), avg(id) from users where id > 5")
+	if output != "(2, 13, 6.5)\n" {
+		t.Fatalf("unexpected aggregate output %q", output)
+	}
+
+	// 数值相等的整数和浮点数属于同一个分组, 文本按原样分组
+	mixed := openTable(t, filepath.Join(t.TempDir(), "mixed.db"))
+	for i, name := range []string{"1", "1.0", "01", "2", "2.5"} {
+		runStatement(t, mixed, fmt.Sprintf("insert %d %s user@a.com", i+1, name))
+	}
+	output = queryOutput(t, mixed, "select username + 0, count(*) from users group by username + 0")
+	if output != "(1, 3)\n(2, 1)\n(2.5, 1)\n" {
+		t.Fatalf("unexpected groups for mixed numeric types %q", output)
+	}
+	output = queryOutput(t, mixed, "select username, count(*) from users group by username")
+	if output != "(1, 1)\n(1.0, 1)\n(01, 1)\n(2, 1)\n(2.5, 1)\n" {
+		t.Fatalf("expected numeric looking text to stay in separate groups, got %q", output)
+	}
+
+	// 只允许一个分组留在内存中, 其余分组都要写入磁盘分区
+	defer func(limit int) { aggregateMaxMemoryGroups = limit }(aggregateMaxMemoryGroups)
+	aggregateMaxMemoryGroups = 1
+
+	output = queryOutput(t, table, "select substr(email, instr(email, '@') + 1), count(*) from users group by 1 = 1, substr(email, instr(email, '@') + 1)")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	sort.Strings(lines)
+	expected = "(a.com, 3)\n(b.com, 1)\n(c.com, 2)\n(d.com, 1)"
+	if strings.Join(lines, "\n") != expected {
+		t.Fatalf("expected %q after spilling, got %q", expected, output)
+	}
+}
+
+func TestSubstr(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+	runStatement(t, table, "insert 1 user1 héllo")
+
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{"substr(username, 2)", "(ser1)\n"},
+		{"substr(username, 2, 2)", "(se)\n"},
+		{"substr(username, 0, 3)", "(use)\n"},
+		{"substr(username, 2, -1)", "()\n"},
+		{"substr(username, 10)", "()\n"},
+		// 长度很大时不能溢出
+		{"substr(username, 2, 9223372036854775807)", "(ser1)\n"},
+		// 按字符而不是字节计算
+		{"length(email)", "(5)\n"},
+		{"substr(email, 2, 1)", "(é)\n"},
+		{"substr(email, 3)", "(llo)\n"},
+		{"instr(email, 'l')", "(3)\n"},
+		{"substr(email, instr(email, 'l'))", "(llo)\n"},
+		{"instr(email, 'z')", "(0)\n"},
+	}
+	for _, c := range cases {
+		if output := queryOutput(t, table, "select "+c.expr+" from users"); output != c.expected {
+			t.Fatalf("%s: expected %q, got %q", c.expr, c.expected, output)
+		}
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+	runStatement(t, table, "insert 1 user1 person1@qq.com")
+
+	// 超出int64的结果改为浮点数, 不回绕
+	cases := []struct {
+		expr     string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "(9.223372036854776e+18)\n"},
+		{"9223372036854775806 + 1", "(9223372036854775807)\n"},
+		{"-9223372036854775807 - 10", "(-9.223372036854776e+18)\n"},
+		{"-9223372036854775807 - 1", "(-9223372036854775808)\n"},
+		{"9223372036854775807 * 2", "(1.8446744073709552e+19)\n"},
+		{"(-9223372036854775807 - 1) * -1", "(9.223372036854776e+18)\n"},
+		{"-4611686018427387904 * 2", "(-9223372036854775808)\n"},
+		{"(-9223372036854775807 - 1) / -1", "(9.223372036854776e+18)\n"},
+		{"(-9223372036854775807 - 1) % -1", "(0)\n"},
+		{"-(-9223372036854775807 - 1)", "(9.223372036854776e+18)\n"},
+		{"abs(-9223372036854775807 - 1)", "(9.223372036854776e+18)\n"},
+		{"abs(-9223372036854775807)", "(9223372036854775807)\n"},
+		{"abs(-9223372036854775808)", "(9.223372036854776e+18)\n"},
+	}
+	for _, c := range cases {
+		if output := queryOutput(t, table, "select "+c.expr+" from users"); output != c.expected {
+			t.Fatalf("%s: expected %q, got %q", c.expr, c.expected, output)
+		}
+	}
+
+	runStatement(t, table, "insert 9223372 user2 person2@qq.com")
+	if output := queryOutput(t, table, "select sum(id * 1000000000000) from users"); output != "(9.223373e+18)\n" {
+		t.Fatalf("expected sum to overflow to real, got %q", output)
+	}
+}
+
+func TestSelectWithoutFrom(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	// 没有FROM时只求值一行, 与表中有多少行无关
+	for i := 1; i <= 3; i++ {
+		cases := []struct {
+			query    string
+			expected string
+		}{
+			{"select 1/0", "(NULL)\n"},
+			{"select 1 + 1, 'a' || 'b'", "(2, ab)\n"},
+			{"select count(*), sum(2)", "(1, 2)\n"},
+			{"select 1 where 0", ""},
+			{"select 2 where 1 group by 1 having count(*) = 1", "(2)\n"},
+		}
+		for _, c := range cases {
+			if output := queryOutput(t, table, c.query); output != c.expected {
+				t.Fatalf("%s: expected %q, got %q", c.query, c.expected, output)
+			}
+		}
+		runStatement(t, table, fmt.Sprintf("insert %d user%d person%d@qq.com", i, i, i))
+	}
+
+	// 单独的select仍然查询默认的表, 没有表时不能引用列
+	if output := queryOutput(t, table, "select"); output != "(1, user1, person1@qq.com)\n(2, user2, person2@qq.com)\n(3, user3, person3@qq.com)\n" {
+		t.Fatalf("unexpected rows for bare select %q", output)
+	}
+	var statement sqlStatement
+	if result := prepareStatement("select id", &statement); result != PREPARE_UNKNOWN_COLUMN {
+		t.Fatalf("expected unknown column without from, got %d", result)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	runStatement(t, table, "create table orders")
+	for i := 1; i <= 4; i++ {
+		runStatement(t, table, fmt.Sprintf("insert %d user%d user%d@a.com", i, i, i))
+	}
+	runStatement(t, table, "insert into orders values (10, 'book', 'user1@a.com')")
+	runStatement(t, table, "insert into orders values (11, 'pen', 'user3@a.com')")
+	runStatement(t, table, "insert into orders values (12, 'cup', 'user1@a.com')")
+	runStatement(t, table, "insert into orders values (3, 'ink', 'nobody@a.com')")
+
+	query := "select u.username, o.username from users u left join orders o on o.email = u.email"
+	output := queryOutput(t, table, query)
+	expected := "(user1, book)\n(user1, cup)\n(user2, NULL)\n(user3, pen)\n(user4, NULL)\n"
+	if output != expected {
+		t.Fatalf("hash join: expected %q, got %q", expected, output)
+	}
+
+	query = "select u.id, o.username from users u join orders o on o.id = u.id"
+	output = queryOutput(t, table, query)
+	if output != "(3, ink)\n" {
+		t.Fatalf("primary key join: unexpected output %q", output)
+	}
+
+	var statement sqlStatement
+	prepareStatement(query, &statement)
+	if loop := statement.sel.plan.loops[1]; loop.table != 1 || loop.typ != accessRowidEq {
+		t.Fatalf("expected join on id to seek with tableFind")
+	}
+
+	output = queryOutput(t, table, "select username, email from sqlite_master")
+	if output != "(orders, create table orders)\n" {
+		t.Fatalf("unexpected catalog %q", output)
+	}
+
+	if result := prepareStatement("select id from users u join orders o on o.id = u.id", &statement); result != PREPARE_AMBIGUOUS_COLUMN {
+		t.Fatalf("expected ambiguous column, got %d", result)
+	}
+
+	// * 展开为所有表的列, 不按列名重新解析
+	output = queryOutput(t, table, "select * from users a join users b on a.id = b.id where a.id < 3")
+	if output != "(1, user1, user1@a.com, 1, user1, user1@a.com)\n(2, user2, user2@a.com, 2, user2, user2@a.com)\n" {
+		t.Fatalf("unexpected select * on a join %q", output)
+	}
+	output = queryOutput(t, table, "select * from users u left join orders o on o.id = u.id where u.id >= 3")
+	if output != "(3, user3, user3@a.com, 3, ink, nobody@a.com)\n(4, user4, user4@a.com, NULL, NULL, NULL)\n" {
+		t.Fatalf("unexpected select * on a left join %q", output)
+	}
+	if output = queryOutput(t, table, "explain query plan select * from users a join users b on a.id = b.id"); output == "" {
+		t.Fatalf("expected a query plan for select * on a join")
+	}
+}
+
+// TestVM 编译出的程序使用预期的访问方式, LEFT JOIN 在每种访问方式下都补NULL行
+func TestVM(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	runStatement(t, table, "create table orders")
+	for i := 1; i <= 3; i++ {
+		runStatement(t, table, fmt.Sprintf("insert %d user%d user%d@a.com", i, i, i))
+	}
+	runStatement(t, table, "insert into orders values (2, 'pen', 'x')")
+	runStatement(t, table, "insert into orders values (5, 'cup', 'y')")
+
+	opcodes := func(query string) map[vmOpcode]int {
+		var statement sqlStatement
+		if result := prepareStatement(query, &statement); result != PREPARE_SUCCESS {
+			t.Fatalf("prepare %q: result %d", query, result)
+		}
+		counts := make(map[vmOpcode]int)
+		for _, in := range statement.program.instructions {
+			counts[in.opcode]++
+		}
+		return counts
+	}
+
+	counts := opcodes("select u.id from users u left join orders o on o.id = u.id where u.id >= 2")
+	if counts[opSeekRowid] != 1 || counts[opSeekGe] != 1 || counts[opNullRow] != 1 || counts[opGe] != 1 || counts[opResultRow] != 1 {
+		t.Fatalf("unexpected seek join program %v", counts)
+	}
+	if counts := opcodes("insert into orders values (?, 'a', 'b')"); counts[opOpenWrite] != 1 || counts[opInsert] != 1 {
+		t.Fatalf("unexpected insert program %v", counts)
+	}
+
+	expected := "(1, NULL)\n(2, pen)\n(3, NULL)\n"
+	queries := []string{
+		"select u.id, o.username from users u left join orders o on o.id = u.id",
+		"select u.id, o.username from users u left join orders o on o.id + 0 = u.id",
+		"select u.id, o.username from users u left join orders o on o.id < u.id + 1 and o.id > u.id - 1",
+	}
+	for _, query := range queries {
+		if output := queryOutput(t, table, query); output != expected {
+			t.Fatalf("%s: expected %q, got %q", query, expected, output)
+		}
+	}
+
+	// WHERE 在补NULL行之后求值
+	output := queryOutput(t, table, "select u.id from users u left join orders o on o.id = u.id where coalesce(o.username, 'none') = 'none'")
+	if output != "(1)\n(3)\n" {
+		t.Fatalf("unexpected where over padded rows %q", output)
+	}
+	output = queryOutput(t, table, "select count(*), count(o.id) from users u left join orders o on o.id = u.id")
+	if output != "(3, 1)\n" {
+		t.Fatalf("unexpected aggregate over left join %q", output)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	details := func(query string) []string {
+		t.Helper()
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			values := rows.Values()
+			out = append(out, values[len(values)-1].String())
+		}
+		return out
+	}
+
+	plan := details("explain query plan select u.id from users u join orders o on o.id = u.id left join orders p on p.email = o.email")
+	expected := []string{
+		"SCAN users AS u",
+		"SEARCH orders AS o USING INTEGER PRIMARY KEY (id=?)",
+		"SEARCH orders AS p USING AUTOMATIC HASH INDEX (p.email=?)",
+	}
+	if strings.Join(plan, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected query plan %q", plan)
+	}
+	if plan := details("explain query plan select email, count(*) from orders group by email"); len(plan) != 2 || plan[1] != "USE HASH TABLE FOR GROUP BY" {
+		t.Fatalf("unexpected group by plan %q", plan)
+	}
+	if plan := details("explain query plan select 1 + 1"); strings.Join(plan, "\n") != "SCAN CONSTANT ROW" {
+		t.Fatalf("unexpected plan without from %q", plan)
+	}
+
+	// explain 不执行语句
+	rows, err := db.Query("explain insert into orders values (1, 'a', 'b')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rows.Columns(), ",") != "addr,opcode,p1,p2,p3,p4,comment" {
+		t.Fatalf("unexpected columns %v", rows.Columns())
+	}
+	var opcodes []string
+	for rows.Next() {
+		opcodes = append(opcodes, rows.Values()[1].String())
+	}
+	if strings.Join(opcodes, ",") != "Value,Value,Value,OpenWrite,Insert,Halt" {
+		t.Fatalf("unexpected program %v", opcodes)
+	}
+	if result, err := db.Exec("explain insert into orders values (1, 'a', 'b')"); err != nil || result.RowsAffected != 0 {
+		t.Fatalf("unexpected explain result %+v %v", result, err)
+	}
+	if rows := details("select * from orders"); len(rows) != 0 {
+		t.Fatalf("explain inserted rows: %q", rows)
+	}
+
+	var prepareResult PrepareResult
+	if _, err := db.Query("explain query select"); !errors.As(err, &prepareResult) || prepareResult != PREPARE_SYNTAX_ERROR {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+}
+
+func TestPlanner(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	query := func(query string) []string {
+		t.Helper()
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var fields []string
+			for _, value := range rows.Values() {
+				fields = append(fields, value.String())
+			}
+			out = append(out, strings.Join(fields, " "))
+		}
+		return out
+	}
+	exec := func(query string) {
+		t.Helper()
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	plan := func(sql string) string {
+		t.Helper()
+		var details []string
+		for _, row := range query("explain query plan " + sql) {
+			details = append(details, strings.SplitN(row, " ", 3)[2])
+		}
+		return strings.Join(details, "; ")
+	}
+
+	exec("create table orders")
+	for i := 1; i <= 20; i++ {
+		exec(fmt.Sprintf("insert into users values (%d, 'user%d', 'user%d@a.com')", i, i, i%5))
+	}
+	exec("insert into orders values (1, 'book', 'user3@a.com')")
+	exec("insert into orders values (2, 'pen', 'user4@a.com')")
+
+	// 主键范围条件从下界开始查找, 超过上界时结束, 结果跨过叶子节点
+	if detail := plan("select id from users where id > 5 and id <= 15"); detail != "SEARCH users USING INTEGER PRIMARY KEY (id>? AND id<=?)" {
+		t.Fatalf("unexpected range plan %q", detail)
+	}
+	if rows := query("select id from users where id > 5 and 15 >= id"); len(rows) != 10 || rows[0] != "6" || rows[9] != "15" {
+		t.Fatalf("unexpected range rows %q", rows)
+	}
+	if rows := query("select id from users where id >= 12.5"); len(rows) != 8 || rows[0] != "13" {
+		t.Fatalf("unexpected range rows %q", rows)
+	}
+	if rows := query("select id from users where id > 'abc'"); len(rows) != 0 {
+		t.Fatalf("unexpected range rows %q", rows)
+	}
+
+	// 没有统计时两张表的估计相同, 按FROM中的顺序连接
+	join := "select u.id, o.username from users u join orders o on o.email = u.email"
+	if detail := plan(join); detail != "SCAN users AS u; SEARCH orders AS o USING AUTOMATIC HASH INDEX (o.email=?)" {
+		t.Fatalf("unexpected join plan before analyze %q", detail)
+	}
+
+	exec("analyze")
+	stats := query("select id, username, email from sqlite_stat1")
+	if strings.Join(stats, "\n") != "0 users 20 20 5\n2 orders 2 2 2" {
+		t.Fatalf("unexpected stats %q", stats)
+	}
+	if detail := plan(join); detail != "SCAN orders AS o; SCAN users AS u" {
+		t.Fatalf("unexpected join plan after analyze %q", detail)
+	}
+
+	// 统计缓存在DB中, 再次准备语句时不读取sqlite_stat1
+	before, _ := db.Counters()
+	if _, err := db.Prepare(join); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := db.Counters()
+	if diff := after.Sub(before); diff.CacheHits != 0 || diff.PagesRead != 0 {
+		t.Fatalf("expected prepare to use the cached stats: %+v", diff)
+	}
+	rows := query(join)
+	sort.Strings(rows)
+	if strings.Join(rows, ",") != "13 book,14 pen,18 book,19 pen,3 book,4 pen,8 book,9 pen" {
+		t.Fatalf("unexpected join rows %q", rows)
+	}
+
+	// 再次analyze覆盖原来的统计
+	exec("insert into orders values (3, 'cup', 'user0@a.com')")
+	exec("analyze orders")
+	if stats := query("select email from sqlite_stat1 where username = 'orders'"); len(stats) != 1 || stats[0] != "3 3 3" {
+		t.Fatalf("unexpected stats after reanalyze %q", stats)
+	}
+	if stats := db.plannerStats()["orders"]; stats == nil || stats.rows != 3 {
+		t.Fatalf("expected cached stats to be reloaded after analyze, got %+v", stats)
+	}
+
+	var executeResult ExecuteResult
+	if _, err := db.Exec("analyze nosuch"); !errors.As(err, &executeResult) || executeResult != EXECUTE_UNKNOWN_TABLE {
+		t.Fatalf("expected unknown table, got %v", err)
+	}
+}
+
+func TestPreparedStatement(t *testing.T) {
+	table := openTable(t, filepath.Join(t.TempDir(), "test.db"))
+
+	insert, result := prepare(table, "insert ? :name :email")
+	if result != PREPARE_SUCCESS {
+		t.Fatalf("prepare insert: result %d", result)
+	}
+	if insert.parameterCount() != 3 || insert.parameterName(2) != ":name" || insert.parameterIndex("email") != 3 {
+		t.Fatalf("unexpected parameters %v", insert.statement.parameters.names)
+	}
+
+	for i := 1; i <= 20; i++ {
+		insert.reset()
+		if err := insert.bind(1, i); err != nil {
+			t.Fatal(err)
+		}
+		if err := insert.bindNamed(":name", fmt.Sprintf("user %d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := insert.bindNamed("email", []byte(fmt.Sprintf("user%d@a.com", i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := insert.execute(); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+
+	insert.reset()
+	insert.bind(1, 21)
+	if err := insert.execute(); err != EXECUTE_TYPE_MISMATCH {
+		t.Fatalf("expected NULL strings to be rejected, got %v", err)
+	}
+	insert.bind(2, nil)
+	insert.bind(3, "user21@a.com")
+	if err := insert.execute(); err != EXECUTE_TYPE_MISMATCH {
+		t.Fatalf("expected NULL username to be rejected, got %v", err)
+	}
+	insert.bind(2, "user 21")
+	if err := insert.execute(); err != nil {
+		t.Fatalf("insert 21: %v", err)
+	}
+	for _, id := range []interface{}{"abc", "7zz", 1.5} {
+		insert.bind(1, id)
+		if err := insert.execute(); err != EXECUTE_TYPE_MISMATCH {
+			t.Fatalf("expected id %v to be rejected, got %v", id, err)
+		}
+	}
+	insert.bind(1, -1)
+	if err := insert.execute(); err != EXECUTE_NEGATIVE_ID {
+		t.Fatalf("expected negative id, got %v", err)
+	}
+	insert.bind(1, 22)
+	insert.bind(2, strings.Repeat("x", COLUMN_USERNAME_SIZE+1))
+	if err := insert.execute(); err != EXECUTE_STRING_TOO_LONG {
+		t.Fatalf("expected string too long, got %v", err)
+	}
+	if err := insert.bind(4, 1); err == nil {
+		t.Fatalf("expected out of range bind to fail")
+	}
+	if err := insert.bind(1, struct{}{}); err == nil {
+		t.Fatalf("expected unsupported type to fail")
+	}
+
+	query, result := prepare(table, "select username from users where id >= ?1 and id < ?1 + ?2")
+	if result != PREPARE_SUCCESS || query.parameterCount() != 2 {
+		t.Fatalf("prepare select: result %d", result)
+	}
+	query.bind(1, 5)
+	query.bind(2, 2)
+	var values [][]Value
+	querySelect(&query.statement, table, func(row []Value) { values = append(values, row) })
+	output := formatRows(values)
+	if output != "(user 5)\n(user 6)\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(DRIVER_NAME, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		res, err := db.Exec("insert into orders values (?, ?, ?)", i, fmt.Sprintf("item %d", i), "a@b.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := res.LastInsertId(); id != int64(i) {
+			t.Fatalf("expected last insert id %d, got %d", i, id)
+		}
+	}
+	if _, err := db.Exec("insert into orders values (1, 'dup', 'x')"); err == nil {
+		t.Fatalf("expected duplicate key error")
+	}
+	if _, err := db.Exec("insert into orders values (?, 'abc', 'x')", "abc"); err == nil {
+		t.Fatalf("expected a text id to be rejected")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("insert into orders values (:id, 'rolled back', 'x')", sql.Named("id", 6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 事务中可以查询, 其他连接的查询不等待事务结束
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("insert into orders values (7, 'rolled back', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	var max int
+	if err := tx.QueryRow("select max(id) from orders").Scan(&max); err != nil || max != 7 {
+		t.Fatalf("expected max id 7 in the transaction, got %d %v", max, err)
+	}
+	if err := db.QueryRow("select min(id) from orders").Scan(&max); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.BeginTx(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	for _, opts := range []*sql.TxOptions{{ReadOnly: true}, {Isolation: sql.LevelSerializable}} {
+		if _, err := db.BeginTx(context.Background(), opts); err == nil {
+			t.Fatalf("expected options %+v to be rejected", opts)
+		}
+	}
+
+	var count int
+	var total float64
+	if err := db.QueryRow("select count(*), sum(id) * 1.0 from orders").Scan(&count, &total); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || total != 15 {
+		t.Fatalf("expected 5 rows with sum 15, got %d %v", count, total)
+	}
+
+	rows, err := db.Query("select id, username from orders where id > ?", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, _ := rows.Columns()
+	if strings.Join(columns, ",") != "id,username" {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+	var names []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if strings.Join(names, ",") != "item 4,item 5" {
+		t.Fatalf("unexpected rows %v", names)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭后数据已经写入文件
+	table := openTable(t, path)
+	output := queryOutput(t, table, "select count(*) from orders")
+	if output != "(5)\n" {
+		t.Fatalf("unexpected count after reopen %q", output)
+	}
+}
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		res, err := db.Exec("insert ? ? :email", i, fmt.Sprintf("user%d", i), Named(":email", "x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.LastInsertId != int64(i) || res.RowsAffected != 1 {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+	var result ExecuteResult
+	if _, err := db.Exec("insert 1 dup dup"); !errors.As(err, &result) || result != EXECUTE_DUPLICATE_KEY {
+		t.Fatalf("expected duplicate key, got %v", err)
+	}
+	var prepareResult PrepareResult
+	if _, err := db.Query("select nope from users"); !errors.As(err, &prepareResult) || prepareResult != PREPARE_UNKNOWN_COLUMN {
+		t.Fatalf("expected unknown column, got %v", err)
+	}
+
+	rows, err := db.Query("select id, username, id * 0.5 from users where id >= ?", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rows.Columns(), ",") != "id,username,id * 0.5" {
+		t.Fatalf("unexpected columns %v", rows.Columns())
+	}
+	var scanned []string
+	for rows.Next() {
+		var id int
+		var name string
+		var half float64
+		if err := rows.Scan(&id, &name, &half); err != nil {
+			t.Fatal(err)
+		}
+		scanned = append(scanned, fmt.Sprintf("%d %s %v", id, name, half))
+	}
+	rows.Close()
+	if strings.Join(scanned, ",") != "2 user2 1,3 user3 1.5" {
+		t.Fatalf("unexpected rows %v", scanned)
+	}
+
+	// 结果行在Next中逐行取出, 取完或关闭之前写语句等待读锁
+	rows, err = db.Query("select id from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted := make(chan error, 1)
+	go func() {
+		_, err := db.Exec("insert 4 user4 user4@a.com")
+		inserted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case err := <-inserted:
+		t.Fatalf("insert finished while the query was open: %v", err)
+	default:
+	}
+	var ids []string
+	for rows.Next() {
+		ids = append(ids, rows.Values()[0].String())
+	}
+	if err := rows.Err(); err != nil || strings.Join(ids, ",") != "1,2,3" {
+		t.Fatalf("unexpected ids %v %v", ids, err)
+	}
+	if err := <-inserted; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert 4 a b"); err == nil {
+		t.Fatalf("expected error on closed database")
+	}
+
+	db, err = Open(path, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("insert 4 a b"); !errors.As(err, &result) || result != EXECUTE_READONLY_DATABASE {
+		t.Fatalf("expected readonly database, got %v", err)
+	}
+	rows, err = db.Query("select count(*) from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() || rows.Values()[0].Int64() != 4 {
+		t.Fatalf("unexpected count %v", rows.Values())
+	}
+	rows.Close()
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.db"), &Options{ReadOnly: true}); err == nil {
+		t.Fatalf("expected read only open of a missing file to fail")
+	}
+}
+
+// queryErr 执行查询并取出全部结果行, 返回Query或Rows.Err的错误
+func queryErr(db *DB, query string) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func TestErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 根节点最多4个子节点, 顺序插入时叶子依次为 7 7 7 13 行
+	for i := 1; i <= 34; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	_, err = db.Exec("insert 35 user user@a.com")
+	if !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+	rows, err := db.Query("select count(*), max(id) from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() || rows.Values()[0].Int64() != 34 || rows.Values()[1].Int64() != 34 {
+		t.Fatalf("tree changed after failed insert: %v", rows.Values())
+	}
+	rows.Close()
+
+	// 根节点的第一个子节点指向不存在的页面
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var child [4]byte
+	childOffset := int64(INTERNAL_NODE_HEADER_SIZE)
+	file.ReadAt(child[:], childOffset)
+	var bad [4]byte
+	binary.LittleEndian.PutUint32(bad[:], TABLE_MAX_PAGES+1)
+	file.WriteAt(bad[:], childOffset)
+	bumpChangeCounter(t, file)
+	if err := queryErr(db, "select * from users"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt, got %v", err)
+	}
+	file.WriteAt(child[:], childOffset)
+	bumpChangeCounter(t, file)
+	if err := queryErr(db, "select * from users"); err != nil {
+		t.Fatalf("expected query to recover after repair, got %v", err)
+	}
+
+	// 叶子页中的一位翻转由校验和发现, 错误中带有页号
+	leaf := int64(2) * int64(PAGE_SIZE)
+	var b [1]byte
+	file.ReadAt(b[:], leaf+int64(LEAF_NODE_HEADER_SIZE)+10)
+	b[0] ^= 0x10
+	file.WriteAt(b[:], leaf+int64(LEAF_NODE_HEADER_SIZE)+10)
+	bumpChangeCounter(t, file)
+	var corrupt *CorruptPageError
+	err = queryErr(db, "select * from users")
+	if !errors.As(err, &corrupt) || corrupt.PageNum != 2 || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected checksum mismatch on page 2, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "page 2") {
+		t.Fatalf("expected error to name the page, got %q", err.Error())
+	}
+	b[0] ^= 0x10
+	file.WriteAt(b[:], leaf+int64(LEAF_NODE_HEADER_SIZE)+10)
+
+	truncated := filepath.Join(t.TempDir(), "truncated.db")
+	if err := os.WriteFile(truncated, make([]byte, PAGE_SIZE+10), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(truncated, nil); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected ErrCorrupt for partial page, got %v", err)
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing", "test.db"), nil); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected ErrIO for missing directory, got %v", err)
+	}
+}
+
+// failingFile 前writes次写入成功, 之后的写入都返回错误
+type failingFile struct {
+	*os.File
+	writes int
+}
+
+func (file *failingFile) WriteAt(b []byte, off int64) (int, error) {
+	if file.writes <= 0 {
+		return 0, errors.New("injected write failure")
+	}
+	file.writes--
+	return file.File.WriteAt(b, off)
+}
+
+// TestCommitWriteFailure 写回中途失败时释放文件锁并丢弃缓存, 已经写入的页面留在文件中
+func TestCommitWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 1; i <= 15; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 插入21只修改右边的叶子和第0页的修改计数, 第0页先写入, 叶子写入失败
+	pager := db.table.pager
+	file := pager.fileDescriptor.(*os.File)
+	pager.fileDescriptor = &failingFile{File: file, writes: 1}
+	if _, err := db.Exec("insert 21 user user@a.com"); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected ErrIO from the failed write, got %v", err)
+	}
+	if pager.inTransaction || pager.lockLevel != noLock {
+		t.Fatalf("expected transaction to end and locks to be released, in transaction %v lock %d", pager.inTransaction, pager.lockLevel)
+	}
+
+	pager.fileDescriptor = &failingFile{File: file}
+	if _, err := db.Exec("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert 22 user user@a.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("commit"); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected ErrIO from commit, got %v", err)
+	}
+	var result ExecuteResult
+	if _, err := db.Exec("rollback"); !errors.As(err, &result) || result != EXECUTE_NO_TRANSACTION {
+		t.Fatalf("expected failed commit to end the transaction, got %v", err)
+	}
+	if pager.lockLevel != noLock {
+		t.Fatalf("expected locks to be released, got %d", pager.lockLevel)
+	}
+
+	// 缓存从文件重新读取, 没有写入的叶子中不会出现新的行
+	pager.fileDescriptor = file
+	rows, err := db.Query("select count(*), max(id) from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() || rows.Values()[0].Int64() != 15 || rows.Values()[1].Int64() != 15 {
+		t.Fatalf("unexpected rows after failed commits: %v", rows.Values())
+	}
+	rows.Close()
+	if _, err := db.Exec("insert 21 user user@a.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLegacyFileFormat 加入校验和之前写入的文件页尾全为0, 打开时不校验, 第一次提交时升级
+func TestLegacyFileFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("create table t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按旧格式重写每一页: 没有修改计数, 文件格式和校验和
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := uint32(len(data)) / PAGE_SIZE
+	for i := uint32(0); i < numPages; i++ {
+		page := data[i*PAGE_SIZE : (i+1)*PAGE_SIZE]
+		for j := PAGE_TRAILER_OFFSET; j < CATALOG_ROOT_OFFSET; j++ {
+			page[j] = 0
+		}
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(db *DB) int64 {
+		t.Helper()
+		rows, err := db.Query("select count(*) from users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatal("expected a row")
+		}
+		return rows.Values()[0].Int64()
+	}
+
+	db, err = Open(path, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(db); n != 20 {
+		t.Fatalf("expected 20 rows in legacy file, got %d", n)
+	}
+	db.Close()
+
+	db, err = Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert 21 user user@a.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format := fileFormat(unsafe.Pointer(&data[0])); format != fileFormatChecksum {
+		t.Fatalf("expected file format %d after upgrade, got %d", fileFormatChecksum, format)
+	}
+	for i := uint32(0); i < uint32(len(data))/PAGE_SIZE; i++ {
+		if !verifyPageChecksum((*[PAGE_SIZE]byte)(data[i*PAGE_SIZE:])) {
+			t.Fatalf("expected page %d to have a checksum after upgrade", i)
+		}
+	}
+
+	db, err = Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := count(db); n != 21 {
+		t.Fatalf("expected 21 rows after upgrade, got %d", n)
+	}
+}
+
+// patchPage 修改文件中的一页并重新计算校验和, 返回修改前的内容
+func patchPage(t *testing.T, path string, pageNum uint32, fn func(node unsafe.Pointer)) [PAGE_SIZE]byte {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var page, original [PAGE_SIZE]byte
+	offset := int64(pageNum) * int64(PAGE_SIZE)
+	if _, err := file.ReadAt(page[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	original = page
+	fn(unsafe.Pointer(&page))
+	if pageNum == 0 {
+		// 恢复原来的内容时也不能让修改计数回退
+		setChangeCounter(unsafe.Pointer(&page), changeCounter(unsafe.Pointer(&original)))
+	}
+	setPageChecksum(&page)
+	if _, err := file.WriteAt(page[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	bumpChangeCounter(t, file)
+	return original
+}
+
+// bumpChangeCounter 模拟其他进程提交: 第0页的修改计数加一, 打开的数据库下次取得文件锁时丢弃缓存
+func bumpChangeCounter(t *testing.T, file *os.File) {
+	t.Helper()
+
+	var page [PAGE_SIZE]byte
+	if _, err := file.ReadAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+	setChangeCounter(unsafe.Pointer(&page), changeCounter(unsafe.Pointer(&page))+1)
+	setPageChecksum(&page)
+	if _, err := file.WriteAt(page[:], 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i <= 20; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("create table t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into t values (1, 'a', 'a@a.com')"); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := db.Check()
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected clean database, got %v %v", problems, err)
+	}
+
+	// users的根节点有两个叶子, 左边1-7, 右边8-20
+	var left, right uint32
+	patchPage(t, path, 0, func(node unsafe.Pointer) {
+		left = *(*uint32)(internalNodeCell(node, 0))
+		right = *(*uint32)(internalNodeRightChild(node))
+	})
+
+	cases := []struct {
+		name    string
+		pageNum uint32
+		patch   func(node unsafe.Pointer)
+		want    string
+	}{
+		{"unsorted keys", left, func(node unsafe.Pointer) {
+			*(*uint32)(leafNodeKey(node, 1)) = 1
+		}, "not greater than previous key"},
+		{"key outside range", right, func(node unsafe.Pointer) {
+			*(*uint32)(leafNodeKey(node, 0)) = 3
+		}, "outside parent range"},
+		{"wrong separator", 0, func(node unsafe.Pointer) {
+			*(*uint32)(internalNodeKey(node, 0)) = 6
+		}, "does not match max key 7"},
+		{"wrong parent", right, func(node unsafe.Pointer) {
+			*(*uint32)(nodeParent(node)) = left
+		}, "parent pointer"},
+		{"broken leaf chain", left, func(node unsafe.Pointer) {
+			*(*uint32)(leafNodeNextLeaf(node)) = 0
+		}, fmt.Sprintf("next leaf is 0, expected %d", right)},
+		{"invalid node type", right, func(node unsafe.Pointer) {
+			*(*uint8)(node) = 7
+		}, "invalid node type"},
+	}
+	for _, c := range cases {
+		original := patchPage(t, path, c.pageNum, c.patch)
+		problems, err := db.Check()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !strings.Contains(strings.Join(problems, "\n"), c.want) {
+			t.Fatalf("%s: expected %q, got %v", c.name, c.want, problems)
+		}
+		patchPage(t, path, c.pageNum, func(node unsafe.Pointer) {
+			*(*[PAGE_SIZE]byte)(node) = original
+		})
+	}
+
+	// 校验和不对的页面作为问题报告, 不中断检查
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var b [1]byte
+	offset := int64(right)*int64(PAGE_SIZE) + int64(LEAF_NODE_HEADER_SIZE)
+	file.ReadAt(b[:], offset)
+	b[0] ^= 0x10
+	file.WriteAt(b[:], offset)
+	problems, err = db.Check()
+	if err != nil || len(problems) != 1 || !strings.Contains(problems[0], "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v %v", problems, err)
+	}
+	b[0] ^= 0x10
+	file.WriteAt(b[:], offset)
+
+	// 文件末尾多出的页面没有被任何表引用
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt(make([]byte, PAGE_SIZE), stat.Size())
+	problems, err = db.Check()
+	want := fmt.Sprintf("page %d: never used", stat.Size()/int64(PAGE_SIZE))
+	if err != nil || len(problems) != 1 || !strings.Contains(problems[0], want) {
+		t.Fatalf("expected %q, got %v %v", want, problems, err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(filepath.Join(dir, "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i <= 15; i++ {
+		if _, err := db.Exec("insert ? ? ?", i, fmt.Sprintf("user %d", i), fmt.Sprintf("it's %d@a.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("insert into orders values (3, 'a ''quoted'' name', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump strings.Builder
+	if err := db.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump.String(), "insert into users values (2, 'user 2', 'it''s 2@a.com');\n") ||
+		!strings.Contains(dump.String(), "create table orders;\ninsert into orders values (3, 'a ''quoted'' name', '');\n") {
+		t.Fatalf("unexpected dump:\n%s", dump.String())
+	}
+
+	replay, err := Open(filepath.Join(dir, "replay.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	for _, line := range strings.Split(strings.TrimSpace(dump.String()), "\n") {
+		if _, err := replay.Exec(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	var again strings.Builder
+	if err := replay.Dump(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != dump.String() {
+		t.Fatalf("replayed dump differs:\n%s\n%s", dump.String(), again.String())
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	input := "email,ID,username\n" +
+		"\"a@b.com, with comma\",1,alice\n" +
+		"\"multi\nline\",2,\"bob \"\"the\"\" builder\"\n" +
+		"x,1,dup\n" +
+		"y,two,z\n" +
+		"z,3\n" +
+		"w,4," + strings.Repeat("a", COLUMN_USERNAME_SIZE+1) + "\n" +
+		"v,5,ok\n"
+	imported, rowErrors, err := db.Import(strings.NewReader(input), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 3 || len(rowErrors) != 4 {
+		t.Fatalf("expected 3 imported and 4 failed, got %d %v", imported, rowErrors)
+	}
+	expected := []struct {
+		line int
+		err  error
+	}{{5, EXECUTE_DUPLICATE_KEY}, {6, EXECUTE_TYPE_MISMATCH}, {7, nil}, {8, PREPARE_STRING_TOO_LONG}}
+	for i, e := range expected {
+		if rowErrors[i].Line != e.line || (e.err != nil && !errors.Is(rowErrors[i], e.err)) {
+			t.Fatalf("row error %d: expected line %d %v, got %v", i, e.line, e.err, rowErrors[i])
+		}
+	}
+	if output := queryOutput(t, db.table, "select * from users"); output != "(1, alice, a@b.com, with comma)\n(2, bob \"the\" builder, multi\nline)\n(5, ok, v)\n" {
+		t.Fatalf("unexpected rows:\n%s", output)
+	}
+
+	// 没有表头时按列的顺序导入到指定的表
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+	imported, rowErrors, err = db.Import(strings.NewReader("7,pen,p@a.com\n8,ink,i@a.com\n"), "orders")
+	if err != nil || imported != 2 || len(rowErrors) != 0 {
+		t.Fatalf("expected 2 imported, got %d %v %v", imported, rowErrors, err)
+	}
+
+	// 表不存在时整个导入失败
+	if _, _, err := db.Import(strings.NewReader("9,a,b\n"), "missing"); !errors.Is(err, EXECUTE_UNKNOWN_TABLE) {
+		t.Fatalf("expected no such table, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i <= 20; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// users: 根节点和两个叶子, 系统表和orders各一个叶子
+	if stats.TotalPages != 5 || stats.InternalPages != 1 || stats.LeafPages != 4 || stats.FreePages != 0 || stats.TreeHeight != 2 {
+		t.Fatalf("unexpected page counts: %+v", stats)
+	}
+	if expected := float64(20+1) / float64(4*LEAF_NODE_MAX_CELLS); stats.LeafFillFactor != expected {
+		t.Fatalf("expected fill factor %v, got %v", expected, stats.LeafFillFactor)
+	}
+	if stats.BytesWritten == 0 || stats.BytesWritten%int64(PAGE_SIZE) != 0 || stats.CacheHits == 0 {
+		t.Fatalf("unexpected cache counters: %+v", stats)
+	}
+
+	// 文件末尾没有被引用的页面算作空闲页
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt(make([]byte, PAGE_SIZE), 5*int64(PAGE_SIZE))
+	file.Close()
+	if stats, err = db.Stats(); err != nil || stats.TotalPages != 6 || stats.FreePages != 1 {
+		t.Fatalf("expected one free page, got %+v %v", stats, err)
+	}
+	// 文件长度变化时丢弃缓存, 上一次统计把页面从文件读回
+	if stats, err = db.Stats(); err != nil || stats.CacheMisses == 0 || stats.BytesRead == 0 {
+		t.Fatalf("expected pages to be read back after the cache reset: %+v", stats)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := db.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= int(LEAF_NODE_MAX_CELLS)+1; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := db.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第14条插入分裂根节点, 每次自动提交都写回修改的页面
+	diff := after.Sub(before)
+	if diff.Splits != 1 || diff.PagesWritten < int64(LEAF_NODE_MAX_CELLS)+1 || diff.CacheHits == 0 {
+		t.Fatalf("unexpected insert counters: %+v", diff)
+	}
+
+	// 重新打开后查询从文件读入页面
+	db.Close()
+	if db, err = Open(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	before, _ = db.Counters()
+	rows, err := db.Query("select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	after, _ = db.Counters()
+	if diff := after.Sub(before); diff.PagesRead == 0 || diff.PagesWritten != 0 || diff.Splits != 0 {
+		t.Fatalf("unexpected select counters: %+v", diff)
+	}
+
+	// 没有其他进程提交过, 缓存在语句之间保留
+	before = after
+	if rows, err = db.Query("select"); err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	after, _ = db.Counters()
+	if diff := after.Sub(before); diff.PagesRead != 0 || diff.CacheHits == 0 {
+		t.Fatalf("expected the second select to use the cache: %+v", diff)
+	}
+
+	// 提交只写回修改的叶子和记录修改计数的第0页
+	before = after
+	if _, err := db.Exec("insert 100 user user@a.com"); err != nil {
+		t.Fatal(err)
+	}
+	after, _ = db.Counters()
+	if diff := after.Sub(before); diff.PagesWritten != 2 || diff.PagesRead != 0 {
+		t.Fatalf("expected insert to write only the dirty pages: %+v", diff)
+	}
+}
+
+func TestTreeExport(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i <= 20; i++ {
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf strings.Builder
+	if err := db.PrintTreeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var tree treeNode
+	if err := json.Unmarshal([]byte(buf.String()), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Type != "internal" || len(tree.Keys) != 1 || tree.Keys[0] != 7 || len(tree.Children) != 2 ||
+		*tree.MinKey != 1 || *tree.MaxKey != 20 {
+		t.Fatalf("unexpected root: %s", buf.String())
+	}
+	left, right := tree.Children[0], tree.Children[1]
+	if left.Type != "leaf" || len(left.Keys) != 7 || *left.NextLeaf != right.Page || *right.NextLeaf != 0 || *right.MinKey != 8 {
+		t.Fatalf("unexpected leaves: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := db.PrintTreeDot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range []string{
+		fmt.Sprintf("page0 -> page%d [label=\"<= 7\"]", left.Page),
+		fmt.Sprintf("page0 -> page%d [label=\"> 7\"]", right.Page),
+		fmt.Sprintf("page%d -> page%d [style=dashed", left.Page, right.Page),
+	} {
+		if !strings.Contains(buf.String(), edge) {
+			t.Fatalf("expected %q in:\n%s", edge, buf.String())
+		}
+	}
+}
+
+func TestPrintPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := 1; i <= 15; i++ {
+		if _, err := db.Exec("insert ? ? ?", i, fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@a.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf strings.Builder
+	if err := db.PrintPage(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"type:        internal", "is_root:     true", "num_keys:    1", "cell 0 @14: child 2 key 7", "file format: 1", "catalog root: 0"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("expected %q in:\n%s", line, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := db.PrintPage(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	unused := LEAF_NODE_HEADER_SIZE + 8*LEAF_NODE_CELL_SIZE
+	for _, line := range []string{
+		"type:        leaf", "parent:      0", "num_cells:   8", "next_leaf:   0",
+		fmt.Sprintf("cell 7 @%d: key 15 row (15, user15, user15@a.com)", LEAF_NODE_HEADER_SIZE+7*LEAF_NODE_CELL_SIZE),
+		fmt.Sprintf("unused space %d..%d", unused, PAGE_TRAILER_OFFSET),
+		"*\n",
+		fmt.Sprintf("%08x\n", PAGE_TRAILER_OFFSET),
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("expected %q in:\n%s", line, buf.String())
+		}
+	}
+
+	// 校验和不匹配时仍然解码文件中的内容
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteAt([]byte{0xff}, int64(PAGE_SIZE)+int64(PAGE_CHECKSUM_OFFSET)-1)
+	bumpChangeCounter(t, file)
+	buf.Reset()
+	if err := db.PrintPage(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "warning: database disk image is malformed: checksum mismatch on page 1") ||
+		!strings.Contains(buf.String(), "num_cells:   8") {
+		t.Fatalf("expected decoded corrupt page, got:\n%s", buf.String())
+	}
+
+	if err := db.PrintPage(&buf, 3); err == nil {
+		t.Fatal("expected error for page past the end of the file")
+	}
+}
+
+// TestConcurrentReadersAndWriters 用 go test -race 运行
+func TestConcurrentReadersAndWriters(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("create table orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	const rowsPerWriter = 15
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, 16)
+
+	// 两个写者交替插入, 倒序插入使分裂发生在叶子中间
+	for w := 0; w < 2; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := rowsPerWriter; i >= 1; i-- {
+				id := i*2 - w
+				if _, err := db.Exec("insert ? user user@a.com", id); err != nil {
+					errs <- fmt.Errorf("insert %d: %w", id, err)
+					return
+				}
+				if _, err := db.Exec("insert into orders values (?, 'item', 'user@a.com')", id); err != nil {
+					errs <- fmt.Errorf("insert order %d: %w", id, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			lastCount := int64(0)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				rows, err := db.Query("select id from users")
+				if err != nil {
+					errs <- err
+					return
+				}
+				previous := int64(0)
+				for rows.Next() {
+					id := rows.Values()[0].Int64()
+					if id <= previous {
+						rows.Close()
+						errs <- fmt.Errorf("scan out of order: %d after %d", id, previous)
+						return
+					}
+					previous = id
+				}
+				if err := rows.Err(); err != nil {
+					errs <- err
+					return
+				}
+
+				rows, err = db.Query("select count(*) from users u join orders o on o.id = u.id")
+				if err != nil {
+					errs <- err
+					return
+				}
+				rows.Next()
+				count := rows.Values()[0].Int64()
+				rows.Close()
+				if count < lastCount {
+					errs <- fmt.Errorf("count went backwards: %d < %d", count, lastCount)
+					return
+				} else {
+					lastCount = count
+				}
+			}
+		}()
+	}
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	rows, err := db.Query("select count(*), sum(id) from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	if rows.Values()[0].Int64() != 2*rowsPerWriter || rows.Values()[1].Int64() != 465 {
+		t.Fatalf("unexpected final state %v", rows.Values())
+	}
+	rows.Close()
+}
+
+// TestLocking 两个DB打开同一个文件, 模拟两个进程
+func TestLocking(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open file description locks are linux only")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	first, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	count := func(db *DB) int64 {
+		t.Helper()
+		rows, err := db.Query("select count(*) from users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		rows.Next()
+		return rows.Values()[0].Int64()
+	}
+
+	// 两边交替写入, 各自都能看到对方提交的数据
+	for i := 1; i <= 10; i++ {
+		db := first
+		if i%2 == 0 {
+			db = second
+		}
+		if _, err := db.Exec("insert ? user user@a.com", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count(first) != 10 || count(second) != 10 {
+		t.Fatalf("expected both handles to see 10 rows")
+	}
+
+	// first 持有reservedLock时second可以读到提交前的数据, 但不能写
+	if _, err := first.Exec("begin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Exec("insert 11 user user@a.com"); err != nil {
+		t.Fatal(err)
+	}
+	if count(second) != 10 {
+		t.Fatalf("uncommitted row visible to another connection")
+	}
+	if _, err := second.Exec("insert 12 user user@a.com"); !errors.Is(err, ErrBusy) || err.Error() != "database is locked" {
+		t.Fatalf("expected database is locked, got %v", err)
+	}
+
+	// second 正在读时first不能提交, 事务保持打开
+	if err := second.table.pager.beginRead(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Exec("commit"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected commit to be busy while reading, got %v", err)
+	}
+	second.table.pager.endRead()
+	if _, err := first.Exec("commit"); err != nil {
+		t.Fatal(err)
+	}
+	if count(second) != 11 {
+		t.Fatalf("expected committed row to be visible")
+	}
+
+	// busy timeout内锁被释放时等待后成功
+	if _, err := first.Exec("begin"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.Exec("rollback")
+	}()
+	second.table.pager.busyTimeout = 5 * time.Second
+	if _, err := second.Exec("insert 12 user user@a.com"); err != nil {
+		t.Fatalf("expected insert to wait for the lock, got %v", err)
+	}
+
+	first.Close()
+	second.Close()
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if count(db) != 12 {
+		t.Fatalf("expected 12 rows after reopen, got %d", count(db))
+	}
+}
+
+func BenchmarkWriteBySwap(b *testing.B) {
+	page := [10240]byte{}
+
+	const (
+		IDSize       int = 8
+		UsernameSize int = 32
+		EmailSize    int = 251
+		RowSize      int = 291
+		PageSize     int = 10240
+
+		IDOffset       int = 0
+		UsernameOffset int = 8
+		EmailOffset    int = 40
+	)
+
+	username := "seven"
+	email := "seven7@gmail.com"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 34; j++ {
+			rowOffset := j * RowSize
+
+			var ub = [UsernameSize]byte{}
+			var eb = [EmailSize]byte{}
+
+			copy(ub[:], []byte(username))
+			copy(eb[:], []byte(email))
+
+			*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(IDOffset))) = uint64(i)
+			*(*[UsernameSize]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(UsernameOffset))) = ub
+			*(*[EmailSize]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(EmailOffset))) = eb
+		}
+
+		x := PageSize / RowSize
+		for k := 0; k < x; k++ {
+			idx := k
+			rowOffset := idx * RowSize
+			*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(IDOffset))) = 0
+			*(*[UsernameSize]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(UsernameOffset))) = [UsernameSize]byte{}
+			*(*[EmailSize]byte)(unsafe.Pointer(uintptr(unsafe.Pointer(&page)) + uintptr(rowOffset) + uintptr(EmailOffset))) = [EmailSize]byte{}
+		}
+	}
+
+}
+
+func BenchmarkWriteByCopy(b *testing.B) {
+	page := [10240]byte{}
+
+	const (
+		IDSize       int = 8
+		UsernameSize int = 32
+		EmailSize    int = 251
+		RowSize      int = 291
+		PageSize     int = 10240
+
+		IDOffset       int = 0
+		UsernameOffset int = 8
+		EmailOffset    int = 40
+	)
+
+	username := "seven"
+	email := "seven7@gmail.com"
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 34; j++ {
+			rowOffset := j * RowSize
+
+			idb := [IDSize]byte{}
+			binary.BigEndian.PutUint64(idb[:], uint64(i))
+			copy(page[rowOffset+IDOffset:], idb[:])
+			copy(page[rowOffset+UsernameOffset:], []byte(username))
+			copy(page[rowOffset+EmailOffset:], []byte(email))
+		}
+
+		x := PageSize / RowSize
+		for k := 0; k < x; k++ {
+			idx := k
+			rowOffset := idx * RowSize
+
+			id := page[rowOffset+IDOffset : rowOffset+IDOffset+IDSize]
+			_ = binary.LittleEndian.Uint64(id)
+			_ = page[rowOffset+UsernameOffset : rowOffset+UsernameOffset+UsernameSize]
+			_ = page[rowOffset+EmailOffset : rowOffset+EmailOffset+EmailSize]
+		}
+	}
+
+}
@@ -0,0 +1,644 @@
+package minisqlite
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+type PrepareResult int
+
+const (
+	PREPARE_SUCCESS PrepareResult = iota
+	PREPARE_NEGATIVE_ID
+	PREPARE_STRING_TOO_LONG
+	PREPARE_UNRECOGNIZED_STATEMENT
+	PREPARE_SYNTAX_ERROR
+	PREPARE_UNKNOWN_COLUMN
+	PREPARE_AMBIGUOUS_COLUMN
+)
+
+type statementType int
+
+const (
+	statementInsert statementType = iota
+	statementSelect
+	statementCreateTable
+	statementBegin
+	statementCommit
+	statementRollback
+	statementAnalyze
+)
+
+type sqlStatement struct {
+	typ         statementType
+	tableName   string
+	rowToInsert tableRow
+	sel         *selectStatement
+
+	// 含有占位符的insert在执行时才求值insertValues生成rowToInsert
+	insertValues []*exprNode
+	parameters   parameterList
+
+	// explain 不为explainNone时只输出编译后的程序或访问方式, 不执行语句
+	explain explainMode
+	program *vmProgram
+}
+
+// prepareStatement 解析语句并编译为字节码程序
+func prepareStatement(input string, statement *sqlStatement) PrepareResult {
+	result := parseStatement(input, statement)
+	if result == PREPARE_SUCCESS {
+		statement.program = compileStatement(statement)
+	}
+	return result
+}
+
+func parseStatement(input string, statement *sqlStatement) PrepareResult {
+	inputStr := input
+
+	if len(inputStr) >= 7 && inputStr[:7] == "explain" {
+		return prepareExplain(input, statement)
+	}
+
+	if len(inputStr) >= 6 && inputStr[:6] == "insert" {
+		return prepareInsert(input, statement)
+	}
+
+	if len(inputStr) >= 6 && inputStr[:6] == "select" {
+		return prepareSelect(input, statement)
+	}
+
+	if len(inputStr) >= 6 && inputStr[:6] == "create" {
+		return prepareCreateTable(input, statement)
+	}
+
+	if len(inputStr) >= 7 && inputStr[:7] == "analyze" {
+		return prepareAnalyze(input, statement)
+	}
+
+	for _, keyword := range []string{"begin", "commit", "end", "rollback"} {
+		if strings.HasPrefix(inputStr, keyword) {
+			return prepareTransaction(input, statement)
+		}
+	}
+
+	return PREPARE_UNRECOGNIZED_STATEMENT
+}
+
+// executeStatement 成功时返回nil, 语句本身的错误为ExecuteResult, 其余为ErrIO等包装后的错误
+func executeStatement(statement *sqlStatement, table *btree) error {
+	if statement.explain != explainNone {
+		return nil
+	}
+	if table.pager.readOnly && statement.isWrite() {
+		return EXECUTE_READONLY_DATABASE
+	}
+
+	// select在querySelect中取读锁, 其余语句修改页面, 持有写锁
+	if statement.typ == statementSelect {
+		return executeSelect(statement, table)
+	}
+	table.pager.lock.Lock()
+	defer table.pager.lock.Unlock()
+
+	program := statement.compiled()
+	execute := func() error {
+		return program.run(statement, table, func(values []Value) {})
+	}
+	if statement.isWrite() {
+		return executeWrite(table, execute)
+	}
+	return execute()
+}
+
+// isWrite 修改数据库的语句, 只读打开时不能执行, 不在事务中时自动提交
+func (statement *sqlStatement) isWrite() bool {
+	switch statement.typ {
+	case statementInsert, statementCreateTable, statementAnalyze:
+		return true
+	}
+	return false
+}
+
+// compiled 返回prepareStatement编译好的程序, 没有经过prepareStatement的语句在这里编译
+func (statement *sqlStatement) compiled() *vmProgram {
+	if statement.program != nil {
+		return statement.program
+	}
+	return compileStatement(statement)
+}
+
+// executeWrite 不在事务中时每条写语句自动开始并提交一个事务, 出错时回滚
+func executeWrite(table *btree, execute func() error) error {
+	pager := table.pager
+	if pager.inTransaction {
+		return execute()
+	}
+
+	if err := pager.pagerBegin(); err != nil {
+		return err
+	}
+	err := execute()
+	if err == nil {
+		err = pager.pagerCommit()
+	}
+	// 写回失败时pagerCommit已经结束了事务
+	if err != nil && pager.inTransaction {
+		pager.pagerRollback()
+	}
+	return err
+}
+
+// @Insert
+func prepareInsert(input string, statement *sqlStatement) PrepareResult {
+	statement.typ = statementInsert
+
+	inputStr := input
+
+	inputs := strings.Fields(inputStr)
+	if len(inputs) >= 2 && strings.EqualFold(inputs[1], "into") {
+		return prepareInsertInto(input, statement)
+	}
+	if len(inputs) < 4 {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	// keyword := inputs[0]
+	idString := inputs[1]
+	username := inputs[2]
+	email := inputs[3]
+	if idString == "" || username == "" || email == "" {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	if isParameter(idString) || isParameter(username) || isParameter(email) {
+		return prepareInsertParameters(statement, inputs[1:4])
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	return fillRowToInsert(statement, int64(id), username, email)
+}
+
+func fillRowToInsert(statement *sqlStatement, id int64, username, email string) PrepareResult {
+	if id < 0 {
+		return PREPARE_NEGATIVE_ID
+	}
+	if id > math.MaxUint32 {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	if len(username) > COLUMN_USERNAME_SIZE {
+		return PREPARE_STRING_TOO_LONG
+	}
+
+	if len(email) > COLUMN_EMAIL_SIZE {
+		return PREPARE_STRING_TOO_LONG
+	}
+
+	statement.rowToInsert = tableRow{id: uint32(id)}
+	copy(statement.rowToInsert.username[:], []byte(username))
+	copy(statement.rowToInsert.email[:], []byte(email))
+
+	return PREPARE_SUCCESS
+}
+
+// prepareInsertParameters insert ? :username 'x@y.com' 形式, 字段可以是占位符或字面值
+func prepareInsertParameters(statement *sqlStatement, fields []string) PrepareResult {
+	statement.insertValues = make([]*exprNode, len(fields))
+	for i, field := range fields {
+		if isParameter(field) {
+			expr, ok := statement.parameters.add(field)
+			if !ok {
+				return PREPARE_SYNTAX_ERROR
+			}
+			statement.insertValues[i] = expr
+			continue
+		}
+
+		value := textValue(field)
+		if i == 0 {
+			id, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return PREPARE_SYNTAX_ERROR
+			}
+			value = integerValue(id)
+		}
+		statement.insertValues[i] = &exprNode{typ: exprLiteral, value: value}
+	}
+	return PREPARE_SUCCESS
+}
+
+// bindInsertValues 检查要插入的值并生成rowToInsert, 错误为ExecuteResult
+// 表中的列都不能为NULL, 绑定NULL时返回EXECUTE_TYPE_MISMATCH
+func bindInsertValues(statement *sqlStatement, values []Value) error {
+	id, ok := integerID(values[0])
+	if !ok || values[1].typ == valueNull || values[2].typ == valueNull {
+		return EXECUTE_TYPE_MISMATCH
+	}
+
+	switch fillRowToInsert(statement, id, values[1].String(), values[2].String()) {
+	case PREPARE_SUCCESS:
+		return nil
+	case PREPARE_NEGATIVE_ID:
+		return EXECUTE_NEGATIVE_ID
+	case PREPARE_STRING_TOO_LONG:
+		return EXECUTE_STRING_TOO_LONG
+	default:
+		return EXECUTE_TYPE_MISMATCH
+	}
+}
+
+// prepareInsertInto insert into <table> values (id, 'username', 'email')
+func prepareInsertInto(input string, statement *sqlStatement) PrepareResult {
+	parser, ok := newParser(input)
+	if !ok || !parser.acceptKeyword("insert") || !parser.acceptKeyword("into") {
+		return PREPARE_SYNTAX_ERROR
+	}
+	parser.parameters = &statement.parameters
+
+	name := parser.next()
+	if name.typ != tokenIdentifier || !parser.acceptKeyword("values") || !parser.acceptOperator("(") {
+		return PREPARE_SYNTAX_ERROR
+	}
+	statement.tableName = name.text
+
+	var exprs []*exprNode
+	for {
+		expr, ok := parser.parseExpr(&exprScope{})
+		if !ok || !isConstantExpr(expr) {
+			return PREPARE_SYNTAX_ERROR
+		}
+		exprs = append(exprs, expr)
+		if !parser.acceptOperator(",") {
+			break
+		}
+	}
+	if !parser.acceptOperator(")") || len(exprs) != len(tableColumns) {
+		return PREPARE_SYNTAX_ERROR
+	}
+	parser.acceptOperator(";")
+	if !parser.atEnd() {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	if statement.parameters.count() > 0 {
+		statement.insertValues = exprs
+		return PREPARE_SUCCESS
+	}
+
+	values := make([]Value, len(exprs))
+	for i, expr := range exprs {
+		// 每一列都不能是NULL
+		if values[i] = evalExpr(expr, nil, nil); values[i].typ == valueNull {
+			return PREPARE_SYNTAX_ERROR
+		}
+	}
+	id, ok := integerID(values[0])
+	if !ok {
+		return PREPARE_SYNTAX_ERROR
+	}
+	return fillRowToInsert(statement, id, values[1].String(), values[2].String())
+}
+
+// integerID id只能是整数或完整的整数文本, 'abc' 和 '7zz' 不会被截断成数字
+func integerID(value Value) (int64, bool) {
+	switch value.typ {
+	case valueInteger:
+		return value.integer, true
+	case valueText:
+		if i, err := strconv.ParseInt(strings.TrimSpace(value.text), 10, 64); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// @Create
+func prepareCreateTable(input string, statement *sqlStatement) PrepareResult {
+	statement.typ = statementCreateTable
+
+	parser, ok := newParser(input)
+	if !ok || !parser.acceptKeyword("create") || !parser.acceptKeyword("table") {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	name := parser.next()
+	if name.typ != tokenIdentifier || isReserved(name) {
+		return PREPARE_SYNTAX_ERROR
+	}
+	if len(name.text) > COLUMN_USERNAME_SIZE {
+		return PREPARE_STRING_TOO_LONG
+	}
+	statement.tableName = name.text
+
+	parser.acceptOperator(";")
+	if !parser.atEnd() {
+		return PREPARE_SYNTAX_ERROR
+	}
+	return PREPARE_SUCCESS
+}
+
+// @Transaction begin [transaction] | commit [transaction] | end [transaction] | rollback [transaction]
+func prepareTransaction(input string, statement *sqlStatement) PrepareResult {
+	parser, ok := newParser(input)
+	if !ok {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	switch {
+	case parser.acceptKeyword("begin"):
+		statement.typ = statementBegin
+	case parser.acceptKeyword("commit"), parser.acceptKeyword("end"):
+		statement.typ = statementCommit
+	case parser.acceptKeyword("rollback"):
+		statement.typ = statementRollback
+	default:
+		return PREPARE_UNRECOGNIZED_STATEMENT
+	}
+
+	parser.acceptKeyword("transaction")
+	parser.acceptOperator(";")
+	if !parser.atEnd() {
+		return PREPARE_SYNTAX_ERROR
+	}
+	return PREPARE_SUCCESS
+}
+
+type resultColumn struct {
+	expr *exprNode
+	name string
+}
+
+type joinKind int
+
+const (
+	joinInner joinKind = iota
+	joinLeft
+)
+
+// tableRef FROM子句中的一张表, 第一张表之后的表通过joinType和on与前面的表连接
+type tableRef struct {
+	name     string
+	alias    string
+	joinType joinKind
+	on       *exprNode
+}
+
+type selectStatement struct {
+	star       bool
+	columns    []resultColumn
+	from       []tableRef
+	width      int
+	where      *exprNode
+	groupBy    []*exprNode
+	having     *exprNode
+	aggregates []*exprNode
+
+	// plan 连接顺序和每张表的访问方式, 由planSelect决定
+	plan *selectPlan
+}
+
+func (sel *selectStatement) isAggregate() bool {
+	return len(sel.groupBy) > 0 || len(sel.aggregates) > 0
+}
+
+func (sel *selectStatement) columnNames() []string {
+	names := make([]string, len(sel.columns))
+	for i, column := range sel.columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+// @Select
+func prepareSelect(input string, statement *sqlStatement) PrepareResult {
+	statement.typ = statementSelect
+
+	parser, ok := newParser(input)
+	if !ok || !parser.acceptKeyword("select") {
+		return PREPARE_SYNTAX_ERROR
+	}
+	parser.parameters = &statement.parameters
+
+	sel := &selectStatement{}
+	statement.sel = sel
+	scope := &exprScope{allowAggregates: true, aggregates: &sel.aggregates}
+
+	if parser.atEnd() || parser.acceptOperator("*") {
+		sel.star = true
+	} else {
+		for {
+			start := parser.pos
+			expr, ok := parser.parseExpr(scope)
+			if !ok {
+				return PREPARE_SYNTAX_ERROR
+			}
+			column := resultColumn{expr: expr, name: parser.textSince(start)}
+
+			if alias, ok := parser.parseAlias(); !ok {
+				return PREPARE_SYNTAX_ERROR
+			} else if alias != "" {
+				column.name = alias
+			}
+			sel.columns = append(sel.columns, column)
+
+			if !parser.acceptOperator(",") {
+				break
+			}
+		}
+	}
+
+	// 没有FROM时只有单独的select查询默认的表, 其他查询只求值一行, 不读任何表
+	if parser.acceptKeyword("from") {
+		if result := parser.parseFrom(sel); result != PREPARE_SUCCESS {
+			return result
+		}
+	} else if sel.star {
+		sel.from = []tableRef{{name: defaultTableName}}
+	}
+
+	if parser.acceptKeyword("where") {
+		if sel.where, ok = parser.parseExpr(&exprScope{}); !ok {
+			return PREPARE_SYNTAX_ERROR
+		}
+	}
+
+	if parser.acceptKeyword("group") {
+		if !parser.acceptKeyword("by") {
+			return PREPARE_SYNTAX_ERROR
+		}
+		for {
+			expr, ok := parser.parseExpr(&exprScope{})
+			if !ok {
+				return PREPARE_SYNTAX_ERROR
+			}
+			sel.groupBy = append(sel.groupBy, expr)
+			if !parser.acceptOperator(",") {
+				break
+			}
+		}
+	}
+
+	if parser.acceptKeyword("having") {
+		if sel.having, ok = parser.parseExpr(scope); !ok {
+			return PREPARE_SYNTAX_ERROR
+		}
+	}
+
+	parser.acceptOperator(";")
+	if !parser.atEnd() {
+		return PREPARE_SYNTAX_ERROR
+	}
+
+	if result := resolveSelect(sel); result != PREPARE_SUCCESS {
+		return result
+	}
+	planSelect(sel, nil)
+	return PREPARE_SUCCESS
+}
+
+// parseAlias 解析可选的 [as] alias, 没有别名时返回空字符串
+func (parser *sqlParser) parseAlias() (string, bool) {
+	explicit := parser.acceptKeyword("as")
+	token := parser.peek()
+	if token.typ != tokenIdentifier || isReserved(token) {
+		return "", !explicit
+	}
+	parser.next()
+	return token.text, true
+}
+
+// parseFrom table [alias] { [inner | left [outer]] join table [alias] on expr | , table [alias] }
+func (parser *sqlParser) parseFrom(sel *selectStatement) PrepareResult {
+	joinType := joinInner
+	for {
+		name := parser.next()
+		if name.typ != tokenIdentifier || isReserved(name) {
+			return PREPARE_SYNTAX_ERROR
+		}
+		alias, ok := parser.parseAlias()
+		if !ok {
+			return PREPARE_SYNTAX_ERROR
+		}
+		ref := tableRef{name: name.text, alias: alias, joinType: joinType}
+
+		if (len(sel.from) > 0 && joinType != joinInner) || parser.isKeyword("on") {
+			if len(sel.from) == 0 || !parser.acceptKeyword("on") {
+				return PREPARE_SYNTAX_ERROR
+			}
+			if ref.on, ok = parser.parseExpr(&exprScope{}); !ok {
+				return PREPARE_SYNTAX_ERROR
+			}
+		}
+		sel.from = append(sel.from, ref)
+
+		switch {
+		case parser.acceptOperator(","):
+			joinType = joinInner
+		case parser.acceptKeyword("join"):
+			joinType = joinInner
+		case parser.acceptKeyword("inner"):
+			joinType = joinInner
+			if !parser.acceptKeyword("join") {
+				return PREPARE_SYNTAX_ERROR
+			}
+		case parser.acceptKeyword("left"):
+			joinType = joinLeft
+			parser.acceptKeyword("outer")
+			if !parser.acceptKeyword("join") {
+				return PREPARE_SYNTAX_ERROR
+			}
+		default:
+			return PREPARE_SUCCESS
+		}
+	}
+}
+
+// resolveSelect 把列名解析为连接后行内的下标, GROUP BY 和 HAVING 中可以引用结果列的别名
+func resolveSelect(sel *selectStatement) PrepareResult {
+	sel.width = len(sel.from) * len(tableColumns)
+
+	if sel.star {
+		for i := 0; i < sel.width; i++ {
+			name := tableColumns[i%len(tableColumns)]
+			sel.columns = append(sel.columns, resultColumn{
+				expr: &exprNode{typ: exprColumn, column: name, columnIndex: i},
+				name: name,
+			})
+		}
+	}
+
+	result := PREPARE_SUCCESS
+	var resolve func(expr *exprNode, visible int, aliases bool)
+	resolve = func(expr *exprNode, visible int, aliases bool) {
+		if expr == nil || result != PREPARE_SUCCESS {
+			return
+		}
+		if expr.typ != exprColumn {
+			resolve(expr.left, visible, aliases)
+			resolve(expr.right, visible, aliases)
+			for _, arg := range expr.args {
+				resolve(arg, visible, aliases)
+			}
+			return
+		}
+
+		matches := 0
+		for k, ref := range sel.from[:visible] {
+			if expr.table != "" && !strings.EqualFold(expr.table, ref.alias) && !strings.EqualFold(expr.table, ref.name) {
+				continue
+			}
+			for i, name := range tableColumns {
+				if strings.EqualFold(expr.column, name) {
+					expr.columnIndex = k*len(tableColumns) + i
+					matches++
+				}
+			}
+		}
+		if matches == 1 {
+			return
+		}
+		if matches > 1 {
+			result = PREPARE_AMBIGUOUS_COLUMN
+			return
+		}
+
+		if aliases && expr.table == "" {
+			for _, column := range sel.columns {
+				if strings.EqualFold(expr.column, column.name) {
+					*expr = *column.expr
+					return
+				}
+			}
+		}
+		result = PREPARE_UNKNOWN_COLUMN
+	}
+
+	for k := range sel.from {
+		resolve(sel.from[k].on, k+1, false)
+	}
+	// *展开的列已经有下标, 连接中再按列名解析会有歧义
+	if !sel.star {
+		for _, column := range sel.columns {
+			resolve(column.expr, len(sel.from), false)
+		}
+	}
+	resolve(sel.where, len(sel.from), false)
+	for _, expr := range sel.groupBy {
+		resolve(expr, len(sel.from), true)
+		walkExpr(expr, func(e *exprNode) bool {
+			if e.typ == exprAggregate {
+				result = PREPARE_SYNTAX_ERROR
+			}
+			return true
+		})
+	}
+	resolve(sel.having, len(sel.from), true)
+
+	return result
+}
@@ -0,0 +1,156 @@
+package minisqlite
+
+import "errors"
+
+// Stats 存储和页面缓存的统计, 见DB.Stats
+type Stats struct {
+	TotalPages    uint32
+	LeafPages     uint32
+	InternalPages uint32
+	// FreePages 没有被任何表引用的页面, 数据库没有空闲页链表, 这些页面不会被重新使用
+	FreePages uint32
+	// TreeHeight 最高的一棵B树的层数, 只有根节点时为1
+	TreeHeight int
+	// LeafFillFactor 所有叶子的平均填充率, 单元数除以LEAF_NODE_MAX_CELLS
+	LeafFillFactor float64
+
+	CachedPages  int
+	CacheHits    int64
+	CacheMisses  int64
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// Counters 打开数据库以来累计的页面读写和叶子分裂次数,
+// 执行语句前后各取一次, 相减得到这条语句的计数
+type Counters struct {
+	// PagesRead 从文件读入的页面, CacheHits 直接使用缓存的页面
+	PagesRead    int64
+	CacheHits    int64
+	PagesWritten int64
+	Splits       int64
+}
+
+// Sub 两次计数的差
+func (counters Counters) Sub(before Counters) Counters {
+	return Counters{
+		PagesRead:    counters.PagesRead - before.PagesRead,
+		CacheHits:    counters.CacheHits - before.CacheHits,
+		PagesWritten: counters.PagesWritten - before.PagesWritten,
+		Splits:       counters.Splits - before.Splits,
+	}
+}
+
+// Counters 返回当前的累计计数, 不读取任何页面
+func (db *DB) Counters() (Counters, error) {
+	if db.table == nil {
+		return Counters{}, errors.New("database is closed")
+	}
+	pager := db.table.pager
+	// 等待正在执行的写语句结束, 避免读到一半的计数
+	pager.lock.RLock()
+	defer pager.lock.RUnlock()
+
+	pager.cacheMu.Lock()
+	defer pager.cacheMu.Unlock()
+	return Counters{
+		PagesRead:    pager.pagesRead,
+		CacheHits:    pager.cacheHits,
+		PagesWritten: pager.pagesWritten,
+		Splits:       pager.splits,
+	}, nil
+}
+
+type statsCollector struct {
+	pager   *filePager
+	stats   *Stats
+	visited []bool
+	cells   uint32
+}
+
+// Stats 遍历所有表的B树统计页面, 缓存和读写的计数从打开数据库开始累计
+func (db *DB) Stats() (Stats, error) {
+	var stats Stats
+	err := db.read(func(pager *filePager) error {
+		pager.cacheMu.Lock()
+		stats.CacheHits = pager.cacheHits
+		stats.CacheMisses = pager.cacheMisses
+		stats.BytesRead = pager.bytesRead
+		pager.cacheMu.Unlock()
+		stats.BytesWritten = pager.bytesWritten
+
+		collector := &statsCollector{pager: pager, stats: &stats, visited: make([]bool, pager.numPages)}
+		if err := collector.collectPage(0, 1); err != nil {
+			return err
+		}
+
+		catalog, err := catalogTable(pager)
+		if err != nil {
+			return err
+		}
+		if catalog != nil {
+			if err := collector.collectPage(catalog.rootPageNum, 1); err != nil {
+				return err
+			}
+			var roots []uint32
+			err := scanTable(catalog, func(row *tableRow) error {
+				roots = append(roots, row.id)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, rootPageNum := range roots {
+				if err := collector.collectPage(rootPageNum, 1); err != nil {
+					return err
+				}
+			}
+		}
+
+		pager.cacheMu.Lock()
+		defer pager.cacheMu.Unlock()
+		for _, page := range pager.pages {
+			if page != nil {
+				stats.CachedPages++
+			}
+		}
+		stats.TotalPages = pager.numPages
+		stats.FreePages = stats.TotalPages - stats.LeafPages - stats.InternalPages
+		if stats.LeafPages > 0 {
+			stats.LeafFillFactor = float64(collector.cells) / float64(stats.LeafPages*LEAF_NODE_MAX_CELLS)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// collectPage 统计以pageNum为根的子树, 损坏的树中重复引用的页面只统计一次
+func (collector *statsCollector) collectPage(pageNum uint32, depth int) error {
+	if pageNum >= uint32(len(collector.visited)) || collector.visited[pageNum] {
+		return nil
+	}
+	collector.visited[pageNum] = true
+
+	node, err := collector.pager.getPage(pageNum)
+	if err != nil {
+		return err
+	}
+	if depth > collector.stats.TreeHeight {
+		collector.stats.TreeHeight = depth
+	}
+
+	if getNodeType(node) == nodeLeaf {
+		collector.stats.LeafPages++
+		collector.cells += *(*uint32)(leafNodeNumCells(node))
+		return nil
+	}
+
+	collector.stats.InternalPages++
+	numKeys := *(*uint32)(internalNodeNumKeys(node))
+	for i := uint32(0); i < numKeys && i < INTERNAL_NODE_MAX_CELLS; i++ {
+		if err := collector.collectPage(*(*uint32)(internalNodeCell(node, i)), depth+1); err != nil {
+			return err
+		}
+	}
+	return collector.collectPage(*(*uint32)(internalNodeRightChild(node)), depth+1)
+}
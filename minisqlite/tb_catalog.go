@@ -0,0 +1,130 @@
+package minisqlite
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	defaultTableName = "users"
+	catalogTableName = "sqlite_master"
+)
+
+// users表的根节点固定在第0页, 其他表记录在系统表sqlite_master中:
+// id 为表的根页号, username 为表名, email 为建表语句
+// 系统表的根页号保存在第0页的页尾, 为0表示还没有创建过其他表
+func catalogRoot(node unsafe.Pointer) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(node) + uintptr(CATALOG_ROOT_OFFSET))
+}
+
+func catalogTable(pager *filePager) (*btree, error) {
+	page, err := pager.getPage(0)
+	if err != nil {
+		return nil, err
+	}
+	rootPageNum := *(*uint32)(catalogRoot(page))
+	if rootPageNum == 0 {
+		return nil, nil
+	}
+	return &btree{pager: pager, rootPageNum: rootPageNum, name: catalogTableName}, nil
+}
+
+// findTable 根据表名查找表, 不存在时返回nil
+func findTable(pager *filePager, name string) (*btree, error) {
+	if strings.EqualFold(name, defaultTableName) {
+		return &btree{pager: pager, rootPageNum: 0, name: defaultTableName}, nil
+	}
+
+	catalog, err := catalogTable(pager)
+	if catalog == nil || err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(name, catalogTableName) {
+		return catalog, nil
+	}
+
+	var row tableRow
+	cursor, err := tableStart(catalog)
+	if err != nil {
+		return nil, err
+	}
+	for !cursor.endOfTable {
+		if err := cursor.cursorRow(&row); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(cString(row.username[:]), name) {
+			return &btree{pager: pager, rootPageNum: row.id, name: cString(row.username[:])}, nil
+		}
+		if err := cursor.cursorAdvance(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// newRootPage 分配一个空的叶子节点作为新B树的根
+func newRootPage(pager *filePager) (uint32, error) {
+	pageNum, err := getUnUsedPageNum(pager)
+	if err != nil {
+		return 0, err
+	}
+	node, err := pager.getPageForWrite(pageNum)
+	if err != nil {
+		return 0, err
+	}
+	initializeLeafNode(node)
+	setNodeRoot(node, true)
+	return pageNum, nil
+}
+
+func executeCreateTable(pager *filePager, name string) error {
+	if strings.EqualFold(name, catalogTableName) {
+		return EXECUTE_TABLE_EXISTS
+	}
+	existing, err := findTable(pager, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return EXECUTE_TABLE_EXISTS
+	}
+
+	catalog, err := catalogTable(pager)
+	if err != nil {
+		return err
+	}
+	// 系统表和新表各需要一页, 先检查避免只创建了系统表
+	if catalog == nil && pager.numPages+2 > TABLE_MAX_PAGES {
+		return EXECUTE_TABLE_FULL
+	}
+	if catalog == nil {
+		catalogPageNum, err := newRootPage(pager)
+		if err != nil {
+			return err
+		}
+
+		page, err := pager.getPageForWrite(0)
+		if err != nil {
+			return err
+		}
+		*(*uint32)(catalogRoot(page)) = catalogPageNum
+		catalog = &btree{pager: pager, rootPageNum: catalogPageNum, name: catalogTableName}
+	}
+
+	rootPageNum, err := newRootPage(pager)
+	if err != nil {
+		return err
+	}
+
+	row := tableRow{id: rootPageNum}
+	copy(row.username[:], []byte(name))
+	copy(row.email[:], []byte(fmt.Sprintf("create table %s", name)))
+
+	if err := insertRow(catalog, &row); err != nil {
+		return err
+	}
+	atomic.AddUint32(&pager.schemaGeneration, 1)
+	return nil
+}
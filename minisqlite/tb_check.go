@@ -0,0 +1,215 @@
+package minisqlite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// integrityChecker 检查所有B树的结构, 记录发现的每一个问题
+// 数据库没有空闲页链表, 所以每一页都必须能从某个表的根节点到达
+type integrityChecker struct {
+	pager    *filePager
+	visited  []bool
+	problems []string
+	// leaves 当前B树中按键顺序排列的叶子页
+	leaves []uint32
+	// unreadable 当前B树中有页面没能读取, 叶子不完整
+	unreadable bool
+}
+
+func (checker *integrityChecker) report(format string, args ...interface{}) {
+	checker.problems = append(checker.problems, fmt.Sprintf(format, args...))
+}
+
+// integrityCheck 返回发现的问题, 没有问题时为空; 只有读文件失败时返回error
+func integrityCheck(pager *filePager) ([]string, error) {
+	checker := &integrityChecker{pager: pager, visited: make([]bool, pager.numPages)}
+
+	if err := checker.checkTable(defaultTableName, 0); err != nil {
+		return nil, err
+	}
+
+	catalog, err := catalogTable(pager)
+	var corrupt *CorruptPageError
+	if errors.As(err, &corrupt) {
+		checker.report("%s", err.Error())
+		return checker.problems, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if catalog != nil {
+		if catalog.rootPageNum >= pager.numPages {
+			checker.report("%s: root page %d out of range", catalogTableName, catalog.rootPageNum)
+		} else {
+			if err := checker.checkTable(catalogTableName, catalog.rootPageNum); err != nil {
+				return nil, err
+			}
+			// 按检查时收集到的叶子读取表的根页号, 系统表损坏时也不会在游标中死循环
+			catalogLeaves := checker.leaves
+			var row tableRow
+			for _, pageNum := range catalogLeaves {
+				node, err := pager.getPage(pageNum)
+				if err != nil {
+					return nil, err
+				}
+				for i := uint32(0); i < *(*uint32)(leafNodeNumCells(node)) && i < LEAF_NODE_MAX_CELLS; i++ {
+					deserializeRow(leafNodeValue(node, i), &row)
+					name := cString(row.username[:])
+					if row.id >= pager.numPages {
+						checker.report("%s: root page %d out of range", name, row.id)
+						continue
+					}
+					if err := checker.checkTable(name, row.id); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	for pageNum, visited := range checker.visited {
+		if !visited {
+			checker.report("page %d: never used", pageNum)
+		}
+	}
+	return checker.problems, nil
+}
+
+// checkTable 检查一棵B树和它的叶子链表
+func (checker *integrityChecker) checkTable(name string, rootPageNum uint32) error {
+	checker.leaves = nil
+	checker.unreadable = false
+	if _, err := checker.checkPage(rootPageNum, 0, true, -1, math.MaxUint32); err != nil {
+		return err
+	}
+	if checker.unreadable {
+		return nil
+	}
+
+	for i, pageNum := range checker.leaves {
+		node, err := checker.pager.getPage(pageNum)
+		if err != nil {
+			return err
+		}
+		expected := uint32(0)
+		if i+1 < len(checker.leaves) {
+			expected = checker.leaves[i+1]
+		}
+		if next := *(*uint32)(leafNodeNextLeaf(node)); next != expected {
+			checker.report("%s: page %d: next leaf is %d, expected %d", name, pageNum, next, expected)
+		}
+	}
+	return nil
+}
+
+// checkPage 检查子树中的键都在(lower, upper]内, 返回子树的最大键, 空子树返回-1
+func (checker *integrityChecker) checkPage(pageNum, parentPageNum uint32, isRoot bool, lower, upper int64) (int64, error) {
+	if pageNum >= checker.pager.numPages {
+		checker.report("page %d: child page %d out of range", parentPageNum, pageNum)
+		return -1, nil
+	}
+	if checker.visited[pageNum] {
+		checker.report("page %d: referenced more than once", pageNum)
+		return -1, nil
+	}
+	checker.visited[pageNum] = true
+
+	node, err := checker.pager.getPage(pageNum)
+	var corrupt *CorruptPageError
+	if errors.As(err, &corrupt) {
+		checker.report("%s", err.Error())
+		checker.unreadable = true
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	if isNodeRoot(node) != isRoot {
+		checker.report("page %d: root flag is %v, expected %v", pageNum, isNodeRoot(node), isRoot)
+	}
+	if !isRoot {
+		if parent := *(*uint32)(nodeParent(node)); parent != parentPageNum {
+			checker.report("page %d: parent pointer is %d, expected %d", pageNum, parent, parentPageNum)
+		}
+	}
+
+	switch getNodeType(node) {
+	case nodeLeaf:
+		return checker.checkLeaf(pageNum, node, lower, upper), nil
+	case nodeInternal:
+		return checker.checkInternal(pageNum, node, lower, upper)
+	default:
+		checker.report("page %d: invalid node type %d", pageNum, getNodeType(node))
+		return -1, nil
+	}
+}
+
+func (checker *integrityChecker) checkLeaf(pageNum uint32, node unsafe.Pointer, lower, upper int64) int64 {
+	checker.leaves = append(checker.leaves, pageNum)
+
+	numCells := *(*uint32)(leafNodeNumCells(node))
+	if numCells > LEAF_NODE_MAX_CELLS {
+		checker.report("page %d: %d cells exceeds maximum %d", pageNum, numCells, LEAF_NODE_MAX_CELLS)
+		numCells = LEAF_NODE_MAX_CELLS
+	}
+
+	maxKey := int64(-1)
+	for i := uint32(0); i < numCells; i++ {
+		key := int64(*(*uint32)(leafNodeKey(node, i)))
+		if i > 0 && key <= maxKey {
+			checker.report("page %d cell %d: key %d is not greater than previous key %d", pageNum, i, key, maxKey)
+		} else if key <= lower || key > upper {
+			checker.report("page %d cell %d: key %d outside parent range (%d, %d]", pageNum, i, key, lower, upper)
+		}
+		if key > maxKey {
+			maxKey = key
+		}
+	}
+	return maxKey
+}
+
+func (checker *integrityChecker) checkInternal(pageNum uint32, node unsafe.Pointer, lower, upper int64) (int64, error) {
+	numKeys := *(*uint32)(internalNodeNumKeys(node))
+	if numKeys == 0 {
+		checker.report("page %d: internal node has no keys", pageNum)
+	}
+	if numKeys > INTERNAL_NODE_MAX_CELLS {
+		checker.report("page %d: %d keys exceeds maximum %d", pageNum, numKeys, INTERNAL_NODE_MAX_CELLS)
+		numKeys = INTERNAL_NODE_MAX_CELLS
+	}
+
+	maxKey := int64(-1)
+	childLower := lower
+	for i := uint32(0); i <= numKeys; i++ {
+		childPageNum := *(*uint32)(internalNodeRightChild(node))
+		childUpper := upper
+		separator := int64(-1)
+		if i < numKeys {
+			childPageNum = *(*uint32)(internalNodeCell(node, i))
+			separator = int64(*(*uint32)(internalNodeKey(node, i)))
+			if separator <= childLower || separator > upper {
+				checker.report("page %d: separator key %d outside range (%d, %d]", pageNum, separator, childLower, upper)
+			}
+			childUpper = separator
+		}
+
+		childMax, err := checker.checkPage(childPageNum, pageNum, false, childLower, childUpper)
+		if err != nil {
+			return -1, err
+		}
+		if i < numKeys && childMax >= 0 && childMax != separator {
+			checker.report("page %d: separator key %d does not match max key %d of child page %d", pageNum, separator, childMax, childPageNum)
+		}
+		if childMax > maxKey {
+			maxKey = childMax
+		}
+		if separator > childLower {
+			childLower = separator
+		}
+	}
+	return maxKey, nil
+}
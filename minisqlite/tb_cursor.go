@@ -0,0 +1,188 @@
+package minisqlite
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+type btreeCursor struct {
+	table      *btree
+	pageNum    uint32
+	cellNum    uint32
+	endOfTable bool
+}
+
+func tableStart(table *btree) (*btreeCursor, error) {
+	cursor, err := tableFind(table, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	numCells := *(*uint32)(leafNodeNumCells(node))
+	cursor.endOfTable = (numCells == 0)
+
+	return cursor, nil
+}
+
+// tableSeek 返回指向第一个主键大于等于key的行的游标
+func tableSeek(table *btree, key uint32) (*btreeCursor, error) {
+	cursor, err := tableFind(table, key)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	numCells := *(*uint32)(leafNodeNumCells(node))
+	// tableFind 返回的插入位置可能在叶子节点的最后一行之后
+	if cursor.cellNum >= numCells {
+		if numCells == 0 {
+			cursor.endOfTable = true
+			return cursor, nil
+		}
+		cursor.cellNum = numCells - 1
+		err = cursor.cursorAdvance()
+	}
+	return cursor, err
+}
+
+func tableFind(table *btree, key uint32) (*btreeCursor, error) {
+	rootPageNum := table.rootPageNum
+	rootNode, err := table.pager.getPage(rootPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	if getNodeType(rootNode) == nodeLeaf {
+		return leafNodeFind(table, rootPageNum, key)
+	} else {
+		return internalNodeFind(table, rootPageNum, key)
+	}
+}
+
+func internalNodeFindChild(node unsafe.Pointer, key uint32) uint32 {
+	numKeys := *(*uint32)(internalNodeNumKeys(node))
+
+	min := uint32(0)
+	max := numKeys
+	for max != min {
+		index := (min + max) / 2
+		indexKey := *(*uint32)(internalNodeKey(node, index))
+		if indexKey >= key {
+			max = index
+		} else {
+			min = index + 1
+		}
+	}
+
+	return min
+}
+
+func internalNodeFind(table *btree, pageNum, key uint32) (*btreeCursor, error) {
+	node, err := table.pager.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	childIndex := internalNodeFindChild(node, key)
+	childPointer, err := internalNodeChild(node, childIndex)
+	if err != nil {
+		return nil, err
+	}
+	childNum := *(*uint32)(childPointer)
+
+	child, err := table.pager.getPage(childNum)
+	if err != nil {
+		return nil, err
+	}
+
+	switch getNodeType(child) {
+	case nodeInternal:
+		return internalNodeFind(table, childNum, key)
+	case nodeLeaf:
+		return leafNodeFind(table, childNum, key)
+	default:
+		return nil, fmt.Errorf("%w: page %d has unknown node type %d", ErrCorrupt, childNum, getNodeType(child))
+	}
+}
+
+func leafNodeFind(table *btree, pageNum uint32, key uint32) (*btreeCursor, error) {
+	node, err := table.pager.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	numCells := *(*uint32)(leafNodeNumCells(node))
+
+	cursor := &btreeCursor{}
+	cursor.table = table
+	cursor.pageNum = pageNum
+
+	minIndex := uint32(0)
+	onePastMaxIndex := numCells
+	for onePastMaxIndex != minIndex {
+		index := (minIndex + onePastMaxIndex) / 2
+		indexKey := *(*uint32)(leafNodeKey(node, index))
+
+		if key == indexKey {
+			cursor.cellNum = index
+			return cursor, nil
+		}
+
+		if key < indexKey {
+			onePastMaxIndex = index
+		} else {
+			minIndex = index + 1
+		}
+	}
+
+	cursor.cellNum = minIndex
+	return cursor, nil
+}
+
+func (cursor *btreeCursor) cursorValue() (unsafe.Pointer, error) {
+	node, err := cursor.table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return nil, err
+	}
+	rowNum := cursor.cellNum
+	return unsafe.Pointer(leafNodeValue(node, rowNum)), nil
+}
+
+func (cursor *btreeCursor) cursorAdvance() error {
+	pageNum := cursor.pageNum
+	node, err := cursor.table.pager.getPage(pageNum)
+	if err != nil {
+		return err
+	}
+
+	cursor.cellNum += 1
+
+	nodeCell := *(*uint32)(leafNodeNumCells(node))
+
+	if cursor.cellNum >= nodeCell {
+		nextPageNum := *(*uint32)(leafNodeNextLeaf(node))
+		if nextPageNum == 0 {
+			cursor.endOfTable = true
+		} else {
+			cursor.pageNum = nextPageNum
+			cursor.cellNum = 0
+		}
+	}
+	return nil
+}
+
+// cursorRow 读取游标所在的行
+func (cursor *btreeCursor) cursorRow(row *tableRow) error {
+	value, err := cursor.cursorValue()
+	if err != nil {
+		return err
+	}
+	deserializeRow(value, row)
+	return nil
+}
@@ -0,0 +1,54 @@
+package minisqlite
+
+import (
+	"errors"
+	"time"
+)
+
+// 跨进程文件锁, 字节范围与SQLite相同, 都在文件末尾之后, 不影响页面读写:
+// pendingByte 上的写锁阻止新的读者进入, reservedByte 上的写锁表示有进程准备写入,
+// SHARED 范围上的读锁表示读者, 写锁表示独占.
+const (
+	pendingByte  = int64(0x40000000)
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = int64(510)
+)
+
+type fileLockLevel int
+
+const (
+	noLock fileLockLevel = iota
+	sharedLock
+	reservedLock
+	pendingLock
+	exclusiveLock
+)
+
+// 等待其他进程释放锁时的重试间隔
+const busyRetryInterval = 5 * time.Millisecond
+
+// ErrBusy 其他进程持有冲突的锁, 在busy timeout内没有释放
+var ErrBusy = errors.New("database is locked")
+
+var errLockConflict = errors.New("lock conflict")
+
+// acquireLock 逐级升到level, 冲突时按busyTimeout重试, 超时返回ErrBusy并停在已经取得的级别
+func (pager *filePager) acquireLock(level fileLockLevel) error {
+	deadline := time.Now().Add(pager.busyTimeout)
+	for pager.lockLevel < level {
+		err := pager.tryLock(pager.lockLevel + 1)
+		if err == errLockConflict {
+			if time.Now().After(deadline) {
+				return ErrBusy
+			}
+			time.Sleep(busyRetryInterval)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pager.lockLevel++
+	}
+	return nil
+}
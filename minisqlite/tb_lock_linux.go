@@ -0,0 +1,5 @@
+package minisqlite
+
+// fcntlSetLock 使用open file description锁(F_OFD_SETLK), 锁属于打开的文件而不是进程,
+// 同一进程中两次打开同一个数据库也会互相阻塞, 关闭其中一个文件不会释放另一个的锁
+const fcntlSetLock = 37
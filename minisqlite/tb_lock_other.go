@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !solaris && !aix
+
+package minisqlite
+
+// 其他平台(windows, plan9, js)没有实现文件锁, 加锁总是成功, 只记录锁的级别.
+// 同一进程内仍由filePager的读写锁同步, 多个进程同时写同一个数据库文件是不安全的
+func (pager *filePager) tryLock(level fileLockLevel) error {
+	return nil
+}
+
+func (pager *filePager) releaseLock(level fileLockLevel) error {
+	if pager.lockLevel > level {
+		pager.lockLevel = level
+	}
+	return nil
+}
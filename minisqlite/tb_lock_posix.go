@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly || solaris || aix
+
+package minisqlite
+
+import "syscall"
+
+// fcntlSetLock 其他系统使用POSIX记录锁, 锁属于进程, 只能在进程之间互斥
+const fcntlSetLock = syscall.F_SETLK
@@ -0,0 +1,75 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || solaris || aix
+
+package minisqlite
+
+// 构建约束逐个列出支持fcntl锁的系统, unix约束要到Go 1.19才有, go.mod要求的是1.18
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fcntlLock 对[start, start+length)加锁或解锁, 锁被其他进程持有时返回errLockConflict
+func (pager *filePager) fcntlLock(typ int16, start, length int64) error {
+	lock := syscall.Flock_t{Type: typ, Whence: 0, Start: start, Len: length}
+	err := syscall.FcntlFlock(pager.fileDescriptor.Fd(), fcntlSetLock, &lock)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return errLockConflict
+	}
+	if err != nil {
+		return fmt.Errorf("%w: fcntl lock: %v", ErrIO, err)
+	}
+	return nil
+}
+
+// tryLock 从当前级别升一级, 不等待
+func (pager *filePager) tryLock(level fileLockLevel) error {
+	switch level {
+	case sharedLock:
+		// 先在pendingByte上加读锁, 有写者在等待独占时不允许新的读者
+		if err := pager.fcntlLock(syscall.F_RDLCK, pendingByte, 1); err != nil {
+			return err
+		}
+		err := pager.fcntlLock(syscall.F_RDLCK, sharedFirst, sharedSize)
+		if unlockErr := pager.fcntlLock(syscall.F_UNLCK, pendingByte, 1); err == nil {
+			err = unlockErr
+		}
+		return err
+	case reservedLock:
+		return pager.fcntlLock(syscall.F_WRLCK, reservedByte, 1)
+	case pendingLock:
+		return pager.fcntlLock(syscall.F_WRLCK, pendingByte, 1)
+	case exclusiveLock:
+		return pager.fcntlLock(syscall.F_WRLCK, sharedFirst, sharedSize)
+	}
+	return nil
+}
+
+// releaseLock 降到level: reservedLock只放弃pendingLock, sharedLock保留读锁, noLock全部释放
+func (pager *filePager) releaseLock(level fileLockLevel) error {
+	if pager.lockLevel <= level {
+		return nil
+	}
+
+	var err error
+	switch level {
+	case reservedLock:
+		if pager.lockLevel == exclusiveLock {
+			err = pager.fcntlLock(syscall.F_RDLCK, sharedFirst, sharedSize)
+		}
+		if unlockErr := pager.fcntlLock(syscall.F_UNLCK, pendingByte, 1); err == nil {
+			err = unlockErr
+		}
+	case sharedLock:
+		if pager.lockLevel == exclusiveLock {
+			err = pager.fcntlLock(syscall.F_RDLCK, sharedFirst, sharedSize)
+		}
+		if unlockErr := pager.fcntlLock(syscall.F_UNLCK, pendingByte, 2); err == nil {
+			err = unlockErr
+		}
+	default:
+		err = pager.fcntlLock(syscall.F_UNLCK, pendingByte, 2+sharedSize)
+	}
+	pager.lockLevel = level
+	return err
+}
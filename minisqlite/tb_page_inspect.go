@@ -0,0 +1,142 @@
+package minisqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+// printPage 解码一页的头部和每个单元, 并以hexdump输出未使用的空间.
+// 校验和不匹配时仍然从文件读出原始内容解码, 方便排查损坏的页面.
+func printPage(w io.Writer, pager *filePager, pageNum uint32) error {
+	if pageNum >= pager.numPages {
+		return fmt.Errorf("page %d out of range, database has %d pages", pageNum, pager.numPages)
+	}
+
+	var page [PAGE_SIZE]byte
+	node, err := pager.getPage(pageNum)
+	var corrupt *CorruptPageError
+	if errors.As(err, &corrupt) {
+		if _, err := pager.fileDescriptor.ReadAt(page[:], int64(pageNum)*int64(PAGE_SIZE)); err != nil {
+			return fmt.Errorf("%w: error reading file: %v", ErrIO, err)
+		}
+		node = unsafe.Pointer(&page)
+		fmt.Fprintf(w, "warning: %s\n", corrupt.Error())
+	} else if err != nil {
+		return err
+	}
+	raw := (*[PAGE_SIZE]byte)(node)[:]
+
+	fmt.Fprintf(w, "page %d\n", pageNum)
+	fmt.Fprintf(w, "  type:        %s\n", nodeTypeName(getNodeType(node)))
+	fmt.Fprintf(w, "  is_root:     %v\n", isNodeRoot(node))
+	fmt.Fprintf(w, "  parent:      %d\n", *(*uint32)(nodeParent(node)))
+
+	used := PAGE_TRAILER_OFFSET
+	switch getNodeType(node) {
+	case nodeLeaf:
+		numCells := *(*uint32)(leafNodeNumCells(node))
+		fmt.Fprintf(w, "  num_cells:   %d\n", numCells)
+		fmt.Fprintf(w, "  next_leaf:   %d\n", *(*uint32)(leafNodeNextLeaf(node)))
+		if numCells > LEAF_NODE_MAX_CELLS {
+			fmt.Fprintf(w, "  num_cells exceeds maximum %d, showing %d cells\n", LEAF_NODE_MAX_CELLS, LEAF_NODE_MAX_CELLS)
+			numCells = LEAF_NODE_MAX_CELLS
+		}
+
+		var row tableRow
+		for i := uint32(0); i < numCells; i++ {
+			deserializeRow(leafNodeValue(node, i), &row)
+			values := rowValues(&row)
+			fields := make([]string, len(values))
+			for j, value := range values {
+				fields[j] = value.String()
+			}
+			fmt.Fprintf(w, "  cell %d @%d: key %d row (%s)\n", i, LEAF_NODE_HEADER_SIZE+i*LEAF_NODE_CELL_SIZE,
+				*(*uint32)(leafNodeKey(node, i)), strings.Join(fields, ", "))
+		}
+		used = LEAF_NODE_HEADER_SIZE + numCells*LEAF_NODE_CELL_SIZE
+	case nodeInternal:
+		numKeys := *(*uint32)(internalNodeNumKeys(node))
+		fmt.Fprintf(w, "  num_keys:    %d\n", numKeys)
+		fmt.Fprintf(w, "  right_child: %d\n", *(*uint32)(internalNodeRightChild(node)))
+		if numKeys > INTERNAL_NODE_MAX_CELLS {
+			fmt.Fprintf(w, "  num_keys exceeds maximum %d, showing %d cells\n", INTERNAL_NODE_MAX_CELLS, INTERNAL_NODE_MAX_CELLS)
+			numKeys = INTERNAL_NODE_MAX_CELLS
+		}
+
+		for i := uint32(0); i < numKeys; i++ {
+			fmt.Fprintf(w, "  cell %d @%d: child %d key %d\n", i, INTERNAL_NODE_HEADER_SIZE+i*INTERNAL_NODE_CELL_SIZE,
+				*(*uint32)(internalNodeCell(node, i)), *(*uint32)(internalNodeKey(node, i)))
+		}
+		used = INTERNAL_NODE_HEADER_SIZE + numKeys*INTERNAL_NODE_CELL_SIZE
+	}
+
+	fmt.Fprintf(w, "  checksum:    0x%08x\n", binary.LittleEndian.Uint32(raw[PAGE_CHECKSUM_OFFSET:]))
+	if pageNum == 0 {
+		fmt.Fprintf(w, "  file format: %d\n", fileFormat(node))
+		fmt.Fprintf(w, "  catalog root: %d\n", *(*uint32)(catalogRoot(node)))
+	}
+
+	fmt.Fprintf(w, "unused space %d..%d (%d bytes):\n", used, PAGE_TRAILER_OFFSET, PAGE_TRAILER_OFFSET-used)
+	hexdump(w, raw, used, PAGE_TRAILER_OFFSET)
+	return nil
+}
+
+func nodeTypeName(typ nodeType) string {
+	switch typ {
+	case nodeInternal:
+		return "internal"
+	case nodeLeaf:
+		return "leaf"
+	}
+	return fmt.Sprintf("invalid (%d)", typ)
+}
+
+// hexdump 按hexdump -C的格式输出page[start:end], 行首为页内偏移, 连续相同的行折叠为 *
+func hexdump(w io.Writer, page []byte, start, end uint32) {
+	var previous []byte
+	folded := false
+	for offset := start - start%16; offset < end; offset += 16 {
+		lineStart, lineEnd := offset, offset+16
+		if lineStart < start {
+			lineStart = start
+		}
+		if lineEnd > end {
+			lineEnd = end
+		}
+
+		line := page[lineStart:lineEnd]
+		if previous != nil && lineStart == offset && lineEnd == offset+16 && bytes.Equal(line, previous) {
+			if !folded {
+				fmt.Fprintf(w, "*\n")
+				folded = true
+			}
+			continue
+		}
+		previous, folded = line, false
+
+		var hex, text strings.Builder
+		for i := offset; i < offset+16; i++ {
+			if i == offset+8 {
+				hex.WriteString(" ")
+			}
+			if i < lineStart || i >= lineEnd {
+				hex.WriteString("   ")
+				text.WriteString(" ")
+				continue
+			}
+			fmt.Fprintf(&hex, "%02x ", page[i])
+			if page[i] >= 0x20 && page[i] < 0x7f {
+				text.WriteByte(page[i])
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "%08x  %s |%s|\n", offset, hex.String(), text.String())
+	}
+	fmt.Fprintf(w, "%08x\n", end)
+}
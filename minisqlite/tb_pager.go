@@ -0,0 +1,479 @@
+package minisqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// pagerFile 数据库文件, 正常为*os.File, 测试中替换为注入错误的实现
+type pagerFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Fd() uintptr
+	Close() error
+}
+
+// filePager 页面缓存
+//
+// lock 是进程内的读写锁: 查询持有读锁, 多个游标可以同时扫描;
+// insert/create和事务控制持有写锁, 修改页面和分裂节点时没有其他读者.
+// 读者也会把页面读入缓存, cacheMu 保护 pages dirty 和 numPages.
+//
+// 进程之间用文件锁(见tb_lock.go)同步. 没有持有文件锁时其他进程可能修改了文件,
+// 所以从noLock取得sharedLock时比较第0页的修改计数, 其他进程提交过才丢弃缓存;
+// 修改过的页面记为脏页, 提交时只写回脏页, 回滚时只丢弃脏页.
+type filePager struct {
+	lock    sync.RWMutex
+	cacheMu sync.Mutex
+
+	fileDescriptor pagerFile
+	fileLength     int64
+	numPages       uint32
+	pages          [TABLE_MAX_PAGES]*[PAGE_SIZE]byte
+	dirty          [TABLE_MAX_PAGES]bool
+	// cacheValid 为false时缓存中的页面不可信, 下次取得文件锁时重新读取;
+	// changeCounter 为缓存对应的第0页修改计数
+	cacheValid    bool
+	changeCounter uint32
+	// schemaGeneration 表和统计可能变化时加一: 重新读取缓存(其他连接提交过), 回滚,
+	// create table和analyze. DB缓存的analyze统计据此失效, 用atomic读写
+	schemaGeneration uint32
+	// readOnly 只读打开时不会取得reservedLock, 也不写回页面
+	readOnly bool
+
+	// fileLockMu 保护 lockLevel 和 readers, readers 为正在执行的查询数
+	fileLockMu  sync.Mutex
+	lockLevel   fileLockLevel
+	readers     int
+	busyTimeout time.Duration
+
+	// inTransaction 写事务中持有reservedLock, 修改只在缓存中, 提交时写回
+	inTransaction bool
+	// checksummed 文件中的页面带有校验和, 读入时校验; 加入校验和之前的文件为false, 第一次提交时升级
+	checksummed bool
+
+	// 打开以来的缓存命中, 读写字节数和叶子分裂次数, cacheHits cacheMisses bytesRead pagesRead 由cacheMu保护,
+	// bytesWritten pagesWritten splits 只在持有写锁时修改. 文件末尾之后的新页面不算读入
+	cacheHits    int64
+	cacheMisses  int64
+	bytesRead    int64
+	pagesRead    int64
+	bytesWritten int64
+	pagesWritten int64
+	splits       int64
+}
+
+func (pager *filePager) getPage(pageNum uint32) (unsafe.Pointer, error) {
+	if pageNum >= TABLE_MAX_PAGES {
+		return nil, fmt.Errorf("%w: tried to fetch page number out of bounds. %d >= %d", ErrCorrupt, pageNum, TABLE_MAX_PAGES)
+	}
+
+	pager.cacheMu.Lock()
+	defer pager.cacheMu.Unlock()
+
+	if pager.pages[pageNum] != nil {
+		pager.cacheHits++
+	} else {
+		pager.cacheMisses++
+		page := &([PAGE_SIZE]byte{})
+		numPages := pager.fileLength / int64(PAGE_SIZE)
+
+		if pager.fileLength%int64(PAGE_SIZE) > 0 {
+			numPages += 1
+		}
+
+		if pageNum <= uint32(numPages) {
+			// ReadAt不改变文件偏移, 多个读者可以同时读
+			bytesRead, err := pager.fileDescriptor.ReadAt(page[:], int64(pageNum)*int64(PAGE_SIZE))
+			if (err != nil && !errors.Is(err, io.EOF)) || bytesRead == -1 {
+				return nil, fmt.Errorf("%w: error reading file: %v, %d", ErrIO, err, bytesRead)
+			}
+			pager.bytesRead += int64(bytesRead)
+			if bytesRead > 0 {
+				pager.pagesRead++
+			}
+
+			// 文件末尾之后的页面还没有写入过, 没有校验和
+			if bytesRead == int(PAGE_SIZE) && pager.checksummed && !verifyPageChecksum(page) {
+				return nil, &CorruptPageError{PageNum: pageNum}
+			}
+		}
+
+		pager.pages[pageNum] = page
+		if pageNum >= pager.numPages {
+			pager.numPages = pageNum + 1
+		}
+	}
+
+	return unsafe.Pointer(pager.pages[pageNum]), nil
+}
+
+// getPageForWrite 返回要修改的页面并记为脏页, 提交时写回
+func (pager *filePager) getPageForWrite(pageNum uint32) (unsafe.Pointer, error) {
+	page, err := pager.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	pager.markDirty(pageNum)
+	return page, nil
+}
+
+func (pager *filePager) markDirty(pageNum uint32) {
+	pager.cacheMu.Lock()
+	pager.dirty[pageNum] = true
+	pager.cacheMu.Unlock()
+}
+
+func (pager *filePager) pagerFlush(pageNum int) error {
+	if pager.pages[pageNum] == nil {
+		return fmt.Errorf("%w: tried to flush null page %d", ErrIO, pageNum)
+	}
+
+	setPageChecksum(pager.pages[pageNum])
+	bytesWrite, err := pager.fileDescriptor.WriteAt(pager.pages[pageNum][:PAGE_SIZE], int64(pageNum)*int64(PAGE_SIZE))
+	if err != nil || bytesWrite == -1 {
+		return fmt.Errorf("%w: error writing: %v", ErrIO, err)
+	}
+	pager.bytesWritten += int64(bytesWrite)
+	pager.pagesWritten++
+	return nil
+}
+
+// 文件格式, 保存在第0页的页尾. 加入校验和之前的文件这里为0, 页面没有校验和
+const (
+	fileFormatLegacy   = uint32(0)
+	fileFormatChecksum = uint32(1)
+)
+
+func fileFormat(node unsafe.Pointer) uint32 {
+	return binary.LittleEndian.Uint32((*[PAGE_SIZE]byte)(node)[FILE_FORMAT_OFFSET:])
+}
+
+func setFileFormat(node unsafe.Pointer, format uint32) {
+	binary.LittleEndian.PutUint32((*[PAGE_SIZE]byte)(node)[FILE_FORMAT_OFFSET:], format)
+}
+
+// changeCounter 第0页的修改计数, 每次提交加一, 其他进程据此判断缓存是否失效
+func changeCounter(node unsafe.Pointer) uint32 {
+	return binary.LittleEndian.Uint32((*[PAGE_SIZE]byte)(node)[CHANGE_COUNTER_OFFSET:])
+}
+
+func setChangeCounter(node unsafe.Pointer, counter uint32) {
+	binary.LittleEndian.PutUint32((*[PAGE_SIZE]byte)(node)[CHANGE_COUNTER_OFFSET:], counter)
+}
+
+// readFileHeader 从文件的第0页读出文件格式和修改计数, 不经过缓存, 这时还不知道是否需要校验
+func (pager *filePager) readFileHeader() (format, counter uint32, err error) {
+	var page [PAGE_SIZE]byte
+	if _, err := pager.fileDescriptor.ReadAt(page[:], 0); err != nil {
+		return 0, 0, fmt.Errorf("%w: error reading file: %v", ErrIO, err)
+	}
+	format = fileFormat(unsafe.Pointer(&page))
+	if format != fileFormatLegacy && format != fileFormatChecksum {
+		return 0, 0, fmt.Errorf("%w: unsupported file format %d", ErrCorrupt, format)
+	}
+	return format, changeCounter(unsafe.Pointer(&page)), nil
+}
+
+// upgradeFileFormat 把加入校验和之前的文件升级为带校验和的格式:
+// 读入所有页面, 提交时连同新的文件格式一起写回, 每一页都写上校验和
+func (pager *filePager) upgradeFileFormat() error {
+	for i := uint32(0); i < pager.numPages; i++ {
+		if _, err := pager.getPageForWrite(i); err != nil {
+			return err
+		}
+	}
+	setFileFormat(unsafe.Pointer(pager.pages[0]), fileFormatChecksum)
+	pager.checksummed = true
+	return nil
+}
+
+// CorruptPageError 从文件读入的页面校验和不匹配, 可能是写入中断或者文件被修改
+type CorruptPageError struct {
+	PageNum uint32
+}
+
+func (err *CorruptPageError) Error() string {
+	return fmt.Sprintf("%s: checksum mismatch on page %d", ErrCorrupt, err.PageNum)
+}
+
+func (err *CorruptPageError) Unwrap() error {
+	return ErrCorrupt
+}
+
+func pageChecksum(page *[PAGE_SIZE]byte) uint32 {
+	hash := crc32.NewIEEE()
+	hash.Write(page[:PAGE_CHECKSUM_OFFSET])
+	hash.Write(page[PAGE_CHECKSUM_OFFSET+PAGE_CHECKSUM_SIZE:])
+	return hash.Sum32()
+}
+
+func setPageChecksum(page *[PAGE_SIZE]byte) {
+	binary.LittleEndian.PutUint32(page[PAGE_CHECKSUM_OFFSET:], pageChecksum(page))
+}
+
+func verifyPageChecksum(page *[PAGE_SIZE]byte) bool {
+	return binary.LittleEndian.Uint32(page[PAGE_CHECKSUM_OFFSET:]) == pageChecksum(page)
+}
+
+// resetCache 丢弃缓存的页面, 按文件当前的长度重新计算页数
+func (pager *filePager) resetCache() error {
+	pager.cacheMu.Lock()
+	defer pager.cacheMu.Unlock()
+
+	info, err := pager.fileDescriptor.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: error stat file: %v", ErrIO, err)
+	}
+	if info.Size()%int64(PAGE_SIZE) != 0 {
+		return fmt.Errorf("%w: db file is not a whole number of pages", ErrCorrupt)
+	}
+
+	format, counter := fileFormatChecksum, uint32(0)
+	if info.Size() > 0 {
+		if format, counter, err = pager.readFileHeader(); err != nil {
+			return err
+		}
+	}
+
+	for i := range pager.pages {
+		pager.pages[i] = nil
+		pager.dirty[i] = false
+	}
+	pager.fileLength = info.Size()
+	pager.numPages = uint32(pager.fileLength / int64(PAGE_SIZE))
+	pager.checksummed = format == fileFormatChecksum
+	pager.changeCounter = counter
+	pager.cacheValid = true
+	atomic.AddUint32(&pager.schemaGeneration, 1)
+
+	// 空文件时users表的根节点还没有写入, 在缓存中初始化, 第一次提交时写回
+	if pager.numPages == 0 {
+		page := &([PAGE_SIZE]byte{})
+		initializeLeafNode(unsafe.Pointer(page))
+		setNodeRoot(unsafe.Pointer(page), true)
+		setFileFormat(unsafe.Pointer(page), fileFormatChecksum)
+		pager.pages[0] = page
+		pager.dirty[0] = true
+		pager.numPages = 1
+	}
+	return nil
+}
+
+// fileChanged 文件的长度或第0页的修改计数与缓存不同时, 其他进程在这期间提交过
+func (pager *filePager) fileChanged() (bool, error) {
+	info, err := pager.fileDescriptor.Stat()
+	if err != nil {
+		return false, fmt.Errorf("%w: error stat file: %v", ErrIO, err)
+	}
+	if info.Size() != pager.fileLength {
+		return true, nil
+	}
+	if info.Size() == 0 {
+		return false, nil
+	}
+	_, counter, err := pager.readFileHeader()
+	if err != nil {
+		return false, err
+	}
+	return counter != pager.changeCounter, nil
+}
+
+// discardDirtyPages 丢弃修改过的页面, 页数恢复为文件的长度, 之后从文件重新读取
+func (pager *filePager) discardDirtyPages() {
+	pager.cacheMu.Lock()
+	defer pager.cacheMu.Unlock()
+
+	pager.numPages = uint32(pager.fileLength / int64(PAGE_SIZE))
+	for i := range pager.pages {
+		if pager.dirty[i] || uint32(i) >= pager.numPages {
+			pager.pages[i] = nil
+			pager.dirty[i] = false
+		}
+	}
+	// 空文件的第0页只在缓存中, 由resetCache重新初始化; 升级文件格式的提交没有完成时重新读取文件格式
+	if pager.numPages == 0 || !pager.checksummed || pager.pages[0] == nil {
+		pager.cacheValid = false
+	}
+}
+
+// lockShared 取得sharedLock, 调用时持有fileLockMu.
+// 从noLock开始时其他进程可能提交过, 修改计数变化时丢弃缓存
+func (pager *filePager) lockShared() error {
+	acquired := false
+	if pager.lockLevel == noLock {
+		if err := pager.acquireLock(sharedLock); err != nil {
+			return err
+		}
+		acquired = true
+		if pager.cacheValid {
+			changed, err := pager.fileChanged()
+			if err != nil {
+				pager.releaseLock(noLock)
+				return err
+			}
+			pager.cacheValid = !changed
+		}
+	}
+	if !pager.cacheValid {
+		if err := pager.resetCache(); err != nil {
+			if acquired {
+				pager.releaseLock(noLock)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// beginRead 查询开始时调用, 调用时持有lock的读锁
+func (pager *filePager) beginRead() error {
+	pager.fileLockMu.Lock()
+	defer pager.fileLockMu.Unlock()
+
+	if err := pager.lockShared(); err != nil {
+		return err
+	}
+	pager.readers++
+	return nil
+}
+
+// endRead 最后一个查询结束并且不在事务中时释放文件锁
+func (pager *filePager) endRead() {
+	pager.fileLockMu.Lock()
+	defer pager.fileLockMu.Unlock()
+
+	pager.readers--
+	if pager.readers == 0 && !pager.inTransaction {
+		pager.releaseLock(noLock)
+	}
+}
+
+// pagerBegin 开始写事务, 取得reservedLock, 其他进程仍然可以读, 但不能开始写
+func (pager *filePager) pagerBegin() error {
+	pager.fileLockMu.Lock()
+	defer pager.fileLockMu.Unlock()
+
+	if err := pager.lockShared(); err != nil {
+		return err
+	}
+	if !pager.readOnly {
+		if err := pager.acquireLock(reservedLock); err != nil {
+			if pager.readers == 0 {
+				pager.releaseLock(noLock)
+			}
+			return err
+		}
+	}
+	pager.inTransaction = true
+	return nil
+}
+
+// pagerCommit 取得exclusiveLock后把脏页写回文件
+// 其他进程还在读时返回ErrBusy, 事务保持打开, 可以重新提交或回滚.
+//
+// 提交不是原子的: 没有日志, 写回中途出错时文件中已经写入的页面无法撤销.
+// 这时丢弃缓存, 结束事务并释放文件锁, 之后从文件重新读取, 文件可能只包含一部分修改
+func (pager *filePager) pagerCommit() error {
+	pager.fileLockMu.Lock()
+	defer pager.fileLockMu.Unlock()
+
+	if !pager.readOnly {
+		if err := pager.acquireLock(exclusiveLock); err != nil {
+			// 放弃pendingLock, 不再阻止其他进程开始读
+			pager.releaseLock(reservedLock)
+			return err
+		}
+		if err := pager.writeDirtyPages(); err != nil {
+			pager.invalidateCache()
+			pager.endTransaction()
+			return err
+		}
+	}
+
+	pager.endTransaction()
+	return nil
+}
+
+// writeDirtyPages 把脏页写回文件并同步, 调用时持有exclusiveLock
+func (pager *filePager) writeDirtyPages() error {
+	if !pager.checksummed {
+		if err := pager.upgradeFileFormat(); err != nil {
+			return err
+		}
+	}
+	if !pager.hasDirtyPages() {
+		return nil
+	}
+
+	// 每次提交修改计数加一, 其他进程取得sharedLock时据此丢弃缓存
+	page, err := pager.getPageForWrite(0)
+	if err != nil {
+		return err
+	}
+	pager.changeCounter++
+	setChangeCounter(page, pager.changeCounter)
+
+	for i := 0; i < int(pager.numPages); i++ {
+		if pager.dirty[i] {
+			if err := pager.pagerFlush(i); err != nil {
+				return err
+			}
+			pager.dirty[i] = false
+		}
+	}
+	if err := pager.fileDescriptor.Sync(); err != nil {
+		return fmt.Errorf("%w: error syncing file: %v", ErrIO, err)
+	}
+	pager.fileLength = int64(pager.numPages) * int64(PAGE_SIZE)
+	return nil
+}
+
+// invalidateCache 丢弃所有缓存的页面, 下次取得文件锁时从文件重新读取
+func (pager *filePager) invalidateCache() {
+	pager.cacheMu.Lock()
+	defer pager.cacheMu.Unlock()
+
+	for i := range pager.pages {
+		pager.pages[i] = nil
+		pager.dirty[i] = false
+	}
+	pager.cacheValid = false
+}
+
+// endTransaction 结束写事务, 没有正在执行的查询时释放文件锁
+func (pager *filePager) endTransaction() {
+	pager.inTransaction = false
+	if pager.readers == 0 {
+		pager.releaseLock(noLock)
+	}
+}
+
+// pagerRollback 丢弃事务中修改过的页面, 之后从文件重新读取
+func (pager *filePager) pagerRollback() {
+	pager.fileLockMu.Lock()
+	defer pager.fileLockMu.Unlock()
+
+	pager.discardDirtyPages()
+	atomic.AddUint32(&pager.schemaGeneration, 1)
+	pager.endTransaction()
+}
+
+func (pager *filePager) hasDirtyPages() bool {
+	for i := 0; i < int(pager.numPages); i++ {
+		if pager.dirty[i] {
+			return true
+		}
+	}
+	return false
+}
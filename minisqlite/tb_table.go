@@ -0,0 +1,274 @@
+package minisqlite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	COLUMN_USERNAME_SIZE = 32
+	COLUMN_EMAIL_SIZE    = 255
+)
+
+const (
+	ID_SIZE       uint32 = uint32(unsafe.Sizeof(tableRow{}.id))
+	USERNAME_SIZE uint32 = uint32(unsafe.Sizeof(tableRow{}.username))
+	EMAIL_SIZE    uint32 = uint32(unsafe.Sizeof(tableRow{}.email))
+
+	ID_OFFSET       uint32 = 0
+	USERNAME_OFFSET uint32 = ID_OFFSET + ID_SIZE
+	EMAIL_OFFSET    uint32 = USERNAME_OFFSET + USERNAME_SIZE
+	ROW_SIZE        uint32 = ID_SIZE + USERNAME_SIZE + EMAIL_SIZE
+)
+
+const (
+	PAGE_SIZE       uint32 = 4096
+	TABLE_MAX_PAGES uint32 = 100
+	ROWS_PER_PAGE   uint32 = PAGE_SIZE / ROW_SIZE
+	TABLE_MAX_ROWS  uint32 = ROWS_PER_PAGE * TABLE_MAX_PAGES
+)
+
+type ExecuteResult int
+
+const (
+	EXECUTE_SUCCESS ExecuteResult = iota
+	EXECUTE_DUPLICATE_KEY
+	EXECUTE_TABLE_FULL
+	EXECUTE_UNKNOWN_TABLE
+	EXECUTE_TABLE_EXISTS
+	EXECUTE_READONLY_TABLE
+	EXECUTE_READONLY_DATABASE
+	EXECUTE_NEGATIVE_ID
+	EXECUTE_STRING_TOO_LONG
+	EXECUTE_TYPE_MISMATCH
+	EXECUTE_TRANSACTION_ACTIVE
+	EXECUTE_NO_TRANSACTION
+)
+
+type tableRow struct {
+	id       uint32
+	username [COLUMN_USERNAME_SIZE]byte
+	email    [COLUMN_EMAIL_SIZE]byte
+}
+
+// tableColumns 表的列名, 顺序与rowValues一致
+var tableColumns = []string{"id", "username", "email"}
+
+func rowValues(row *tableRow) []Value {
+	return []Value{
+		integerValue(int64(row.id)),
+		textValue(cString(row.username[:])),
+		textValue(cString(row.email[:])),
+	}
+}
+
+// cString 去掉定长字段末尾的\0填充
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// btree
+type btree struct {
+	pager       *filePager
+	rootPageNum uint32
+	name        string
+}
+
+// dbOpen readOnly时文件必须已经存在
+func dbOpen(filename string, readOnly bool) (*btree, error) {
+	table := &btree{name: defaultTableName}
+	if err := table.pagerOpen(filename, readOnly); err != nil {
+		return nil, err
+	}
+
+	table.rootPageNum = 0
+	return table, nil
+}
+
+// dbClose 回滚没有提交的事务, 释放文件锁并关闭文件
+// 提交时已经写回了所有修改, 缓存中的页面直接丢弃
+func (table *btree) dbClose() error {
+	pager := table.pager
+	pager.lock.Lock()
+	defer pager.lock.Unlock()
+
+	if pager.inTransaction {
+		pager.pagerRollback()
+	}
+
+	err := table.pager.fileDescriptor.Close()
+	if err != nil {
+		err = fmt.Errorf("%w: error closing db file: %v", ErrIO, err)
+	}
+
+	for i := 0; i < int(TABLE_MAX_PAGES); i++ {
+		table.pager.pages[i] = nil
+	}
+	table.pager = nil
+	table = nil
+	return err
+}
+
+func (table *btree) pagerOpen(filename string, readOnly bool) error {
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, flag, syscall.S_IWUSR|syscall.S_IRUSR)
+	if err != nil {
+		return fmt.Errorf("%w: unable to open file: %v", ErrIO, err)
+	}
+
+	fileLength, err := file.Seek(0, os.SEEK_END)
+	if err != nil || fileLength == -1 {
+		file.Close()
+		return fmt.Errorf("%w: error seeking file: %v", ErrIO, err)
+	}
+
+	if fileLength%int64(PAGE_SIZE) != 0 {
+		file.Close()
+		return fmt.Errorf("%w: db file is not a whole number of pages", ErrCorrupt)
+	}
+	if readOnly && fileLength == 0 {
+		file.Close()
+		return fmt.Errorf("%w: unable to open file: %s is empty", ErrIO, filename)
+	}
+
+	pager := &filePager{}
+	pager.fileDescriptor = file
+	pager.fileLength = fileLength
+	pager.numPages = uint32(fileLength) / PAGE_SIZE
+	pager.readOnly = readOnly
+
+	table.pager = pager
+	for i := uint32(0); i < TABLE_MAX_PAGES; i++ {
+		table.pager.pages[i] = nil
+	}
+	return nil
+}
+
+func insertRow(table *btree, rowToInsert *tableRow) error {
+	keyToInsert := rowToInsert.id
+
+	cursor, err := tableFind(table, keyToInsert)
+	if err != nil {
+		return err
+	}
+
+	node, err := table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return err
+	}
+	numCells := *(*uint32)(leafNodeNumCells(node))
+	if cursor.cellNum < numCells {
+		keyAtIndex := *(*uint32)(leafNodeKey(node, cursor.cellNum))
+		if keyAtIndex == keyToInsert {
+			return EXECUTE_DUPLICATE_KEY
+		}
+	}
+
+	if err := leafNodeInsert(cursor, rowToInsert.id, rowToInsert); err != nil {
+		if errors.Is(err, ErrFull) {
+			return EXECUTE_TABLE_FULL
+		}
+		return err
+	}
+
+	cursor = nil
+
+	return nil
+}
+
+// replaceRow 主键已存在时覆盖原来的行, 否则插入
+func replaceRow(table *btree, row *tableRow) error {
+	cursor, err := tableFind(table, row.id)
+	if err != nil {
+		return err
+	}
+	node, err := table.pager.getPage(cursor.pageNum)
+	if err != nil {
+		return err
+	}
+	if cursor.cellNum < *(*uint32)(leafNodeNumCells(node)) && *(*uint32)(leafNodeKey(node, cursor.cellNum)) == row.id {
+		table.pager.markDirty(cursor.pageNum)
+		serializeRow(row, leafNodeValue(node, cursor.cellNum))
+		return nil
+	}
+	return insertRow(table, row)
+}
+
+func executeTransaction(typ statementType, pager *filePager) error {
+	if typ == statementBegin {
+		if pager.inTransaction {
+			return EXECUTE_TRANSACTION_ACTIVE
+		}
+		return pager.pagerBegin()
+	}
+
+	if !pager.inTransaction {
+		return EXECUTE_NO_TRANSACTION
+	}
+	if typ == statementCommit {
+		return pager.pagerCommit()
+	}
+	pager.pagerRollback()
+	return nil
+}
+
+func executeSelect(statement *sqlStatement, table *btree) error {
+	return querySelect(statement, table, func(values []Value) {})
+}
+
+// querySelect 执行查询, 每个结果行调用一次output
+func querySelect(statement *sqlStatement, table *btree, output func(values []Value)) error {
+	vm, release, err := openSelect(statement, table)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for {
+		row, err := vm.step()
+		if err != nil || row == nil {
+			return err
+		}
+		output(row)
+	}
+}
+
+// openSelect 取得读锁后开始执行查询, 由调用方逐行step, 结束后调用release释放读锁
+func openSelect(statement *sqlStatement, table *btree) (*virtualMachine, func(), error) {
+	pager := table.pager
+	pager.lock.RLock()
+	if err := pager.beginRead(); err != nil {
+		pager.lock.RUnlock()
+		return nil, nil, err
+	}
+
+	vm := statement.compiled().start(statement, table)
+	release := func() {
+		vm.close()
+		pager.endRead()
+		pager.lock.RUnlock()
+	}
+	return vm, release, nil
+}
+
+func serializeRow(source *tableRow, destination unsafe.Pointer) {
+	*(*uint32)(unsafe.Pointer(uintptr(destination) + uintptr(ID_OFFSET))) = source.id
+	*(*([COLUMN_USERNAME_SIZE]byte))(unsafe.Pointer(uintptr(destination) + uintptr(USERNAME_OFFSET))) = source.username
+	*(*([COLUMN_EMAIL_SIZE]byte))(unsafe.Pointer(uintptr(destination) + uintptr(EMAIL_OFFSET))) = source.email
+}
+
+func deserializeRow(source unsafe.Pointer, destination *tableRow) {
+	destination.id = *(*uint32)(unsafe.Pointer(uintptr(source) + uintptr(ID_OFFSET)))
+	destination.username = *(*[COLUMN_USERNAME_SIZE]byte)(unsafe.Pointer(uintptr(source) + uintptr(USERNAME_OFFSET)))
+	destination.email = *(*[COLUMN_EMAIL_SIZE]byte)(unsafe.Pointer(uintptr(source) + uintptr(EMAIL_OFFSET)))
+}
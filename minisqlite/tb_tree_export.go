@@ -0,0 +1,135 @@
+package minisqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// treeNode B树的一个页面, 用于导出.btree dot和.btree json
+type treeNode struct {
+	Page uint32 `json:"page"`
+	// Type 为"internal"或"leaf"
+	Type string `json:"type"`
+	// Keys 内部节点为分隔键, 叶子为单元的键
+	Keys []uint32 `json:"keys"`
+	// MinKey MaxKey 子树中实际存在的键的范围, 空子树时省略
+	MinKey   *uint32     `json:"min_key,omitempty"`
+	MaxKey   *uint32     `json:"max_key,omitempty"`
+	Children []*treeNode `json:"children,omitempty"`
+	// NextLeaf 叶子链表中的下一页, 0表示最后一个叶子
+	NextLeaf *uint32 `json:"next_leaf,omitempty"`
+}
+
+// loadTree 读出以pageNum为根的子树, 一个页面被引用两次时返回ErrCorrupt, 避免损坏的树中死循环
+func loadTree(pager *filePager, pageNum uint32, visited map[uint32]bool) (*treeNode, error) {
+	if visited[pageNum] {
+		return nil, fmt.Errorf("%w: page %d referenced more than once", ErrCorrupt, pageNum)
+	}
+	visited[pageNum] = true
+
+	node, err := pager.getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &treeNode{Page: pageNum, Keys: []uint32{}}
+	switch getNodeType(node) {
+	case nodeInternal:
+		tree.Type = "internal"
+		numKeys := *(*uint32)(internalNodeNumKeys(node))
+		if numKeys > INTERNAL_NODE_MAX_CELLS {
+			return nil, fmt.Errorf("%w: page %d has %d keys", ErrCorrupt, pageNum, numKeys)
+		}
+		for i := uint32(0); i <= numKeys; i++ {
+			child := *(*uint32)(internalNodeRightChild(node))
+			if i < numKeys {
+				child = *(*uint32)(internalNodeCell(node, i))
+				tree.Keys = append(tree.Keys, *(*uint32)(internalNodeKey(node, i)))
+			}
+			subtree, err := loadTree(pager, child, visited)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, subtree)
+			if tree.MinKey == nil {
+				tree.MinKey = subtree.MinKey
+			}
+			if subtree.MaxKey != nil {
+				tree.MaxKey = subtree.MaxKey
+			}
+		}
+	case nodeLeaf:
+		tree.Type = "leaf"
+		numCells := *(*uint32)(leafNodeNumCells(node))
+		if numCells > LEAF_NODE_MAX_CELLS {
+			return nil, fmt.Errorf("%w: page %d has %d cells", ErrCorrupt, pageNum, numCells)
+		}
+		for i := uint32(0); i < numCells; i++ {
+			tree.Keys = append(tree.Keys, *(*uint32)(leafNodeKey(node, i)))
+		}
+		if numCells > 0 {
+			tree.MinKey = &tree.Keys[0]
+			tree.MaxKey = &tree.Keys[numCells-1]
+		}
+		next := *(*uint32)(leafNodeNextLeaf(node))
+		tree.NextLeaf = &next
+	default:
+		return nil, fmt.Errorf("%w: page %d has invalid node type %d", ErrCorrupt, pageNum, getNodeType(node))
+	}
+	return tree, nil
+}
+
+func printTreeJSON(w io.Writer, tree *treeNode) error {
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// printTreeDot 每个页面一个节点, 实线为父节点到子节点, 边上是子树的键范围, 虚线为叶子链表
+func printTreeDot(w io.Writer, tree *treeNode) error {
+	var b strings.Builder
+	b.WriteString("digraph btree {\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	writeDotNode(&b, tree)
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeDotNode(b *strings.Builder, tree *treeNode) {
+	keys := make([]string, len(tree.Keys))
+	for i, key := range tree.Keys {
+		keys[i] = fmt.Sprint(key)
+	}
+
+	label := fmt.Sprintf("page %d %s\\nkeys: %s", tree.Page, tree.Type, strings.Join(keys, " "))
+	if tree.MinKey != nil {
+		label += fmt.Sprintf("\\nrange: %d..%d", *tree.MinKey, *tree.MaxKey)
+	}
+	fmt.Fprintf(b, "  page%d [label=\"%s\"];\n", tree.Page, label)
+
+	for i, child := range tree.Children {
+		var edge string
+		switch {
+		case len(tree.Keys) == 0:
+			edge = "all"
+		case i == 0:
+			edge = fmt.Sprintf("<= %d", tree.Keys[0])
+		case i == len(tree.Keys):
+			edge = fmt.Sprintf("> %d", tree.Keys[i-1])
+		default:
+			edge = fmt.Sprintf("%d < key <= %d", tree.Keys[i-1], tree.Keys[i])
+		}
+		fmt.Fprintf(b, "  page%d -> page%d [label=\"%s\"];\n", tree.Page, child.Page, edge)
+		writeDotNode(b, child)
+	}
+
+	if tree.NextLeaf != nil && *tree.NextLeaf != 0 {
+		fmt.Fprintf(b, "  page%d -> page%d [style=dashed, constraint=false, label=\"next\"];\n", tree.Page, *tree.NextLeaf)
+	}
+}
@@ -1,17 +1,17 @@
-package main
+package minisqlite
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"unsafe"
 )
 
 // Node Header Format
-type NodeType uint32
+type nodeType uint32
 
 const (
-	NODE_INTERNAL NodeType = iota
-	NODE_LEAF
+	nodeInternal nodeType = iota
+	nodeLeaf
 )
 
 // Common Node Header Layout
@@ -26,6 +26,20 @@ const (
 	COMMON_NODE_HEADER_SIZE = NODE_TYPE_SIZE + IS_ROOT_SIZE + PARENT_POINTER_SIZE
 )
 
+// Page Trailer Layout 页尾布局, 修改计数, 文件格式和系统表根页号只有第0页使用, 每一页都有校验和
+const (
+	CATALOG_ROOT_SIZE     = uint32(unsafe.Sizeof(uint32(0)))
+	CATALOG_ROOT_OFFSET   = PAGE_SIZE - CATALOG_ROOT_SIZE
+	PAGE_CHECKSUM_SIZE    = uint32(unsafe.Sizeof(uint32(0)))
+	PAGE_CHECKSUM_OFFSET  = CATALOG_ROOT_OFFSET - PAGE_CHECKSUM_SIZE
+	FILE_FORMAT_SIZE      = uint32(unsafe.Sizeof(uint32(0)))
+	FILE_FORMAT_OFFSET    = PAGE_CHECKSUM_OFFSET - FILE_FORMAT_SIZE
+	CHANGE_COUNTER_SIZE   = uint32(unsafe.Sizeof(uint32(0)))
+	CHANGE_COUNTER_OFFSET = FILE_FORMAT_OFFSET - CHANGE_COUNTER_SIZE
+	PAGE_TRAILER_SIZE     = CATALOG_ROOT_SIZE + PAGE_CHECKSUM_SIZE + FILE_FORMAT_SIZE + CHANGE_COUNTER_SIZE
+	PAGE_TRAILER_OFFSET   = PAGE_SIZE - PAGE_TRAILER_SIZE
+)
+
 // Leaf Node Format
 const (
 	LEAF_NODE_NUM_CELLS_SIZE   = uint32(unsafe.Sizeof(uint32(0)))
@@ -42,7 +56,7 @@ const (
 	LEAF_NODE_VALUE_SIZE      = ROW_SIZE
 	LEAF_NODE_VALUE_OFFSET    = LEAF_NODE_KEY_OFFSET + LEAF_NODE_KEY_SIZE
 	LEAF_NODE_CELL_SIZE       = LEAF_NODE_KEY_SIZE + LEAF_NODE_VALUE_SIZE
-	LEAF_NODE_SPACE_FOR_CELLS = PAGE_SIZE - LEAF_NODE_HEADER_SIZE
+	LEAF_NODE_SPACE_FOR_CELLS = PAGE_SIZE - LEAF_NODE_HEADER_SIZE - PAGE_TRAILER_SIZE
 	LEAF_NODE_MAX_CELLS       = +LEAF_NODE_SPACE_FOR_CELLS / LEAF_NODE_CELL_SIZE
 )
 
@@ -85,22 +99,20 @@ func internalNodeCell(node unsafe.Pointer, cellNum uint32) unsafe.Pointer {
 }
 
 /*
-  在内部节点node中根据子页面索引获得内存坐标
-  不能超过该内存节点最大元素个数
-  小于childNum 都是在leftChild中存储
-  等于时 是rightChild
+在内部节点node中根据子页面索引获得内存坐标
+不能超过该内存节点最大元素个数
+小于childNum 都是在leftChild中存储
+等于时 是rightChild
 */
-func internalNodeChild(node unsafe.Pointer, childNum uint32) unsafe.Pointer {
+func internalNodeChild(node unsafe.Pointer, childNum uint32) (unsafe.Pointer, error) {
 	numKeys := *(*uint32)(internalNodeNumKeys(node))
 	if childNum > numKeys {
-		fmt.Printf("Tried to access child_num %d > num_keys %d\n", childNum, numKeys)
-		os.Exit(EXIT_FAILURE)
+		return nil, fmt.Errorf("%w: tried to access child_num %d > num_keys %d", ErrCorrupt, childNum, numKeys)
 	} else if childNum == numKeys {
-		return internalNodeRightChild(node)
+		return internalNodeRightChild(node), nil
 	} else {
-		return internalNodeCell(node, childNum)
+		return internalNodeCell(node, childNum), nil
 	}
-	return nil
 }
 
 func internalNodeKey(node unsafe.Pointer, keyNum uint32) unsafe.Pointer {
@@ -108,7 +120,7 @@ func internalNodeKey(node unsafe.Pointer, keyNum uint32) unsafe.Pointer {
 }
 
 func initializeInternalNode(node unsafe.Pointer) {
-	setNodeType(node, NODE_INTERNAL)
+	setNodeType(node, nodeInternal)
 	setNodeRoot(node, false)
 	*(*uint32)(internalNodeNumKeys(node)) = 0
 }
@@ -116,9 +128,9 @@ func initializeInternalNode(node unsafe.Pointer) {
 // 遍历通用节点
 func getNodeMaxKey(node unsafe.Pointer) uint32 {
 	switch getNodeType(node) {
-	case NODE_INTERNAL:
+	case nodeInternal:
 		return *(*uint32)(internalNodeKey(node, (*(*uint32)(internalNodeNumKeys(node)))-1))
-	case NODE_LEAF:
+	case nodeLeaf:
 		return *(*uint32)(leafNodeKey(node, (*(*uint32)(leafNodeNumCells(node)))-1))
 	}
 
@@ -130,14 +142,42 @@ func isNodeRoot(node unsafe.Pointer) bool {
 	return value
 }
 
-func leafNodeSplitAndInsert(cursor *Cursor, key uint32, value *Row) {
-	oldNode := cursor.table.pager.getPage(cursor.pageNum)
+func leafNodeSplitAndInsert(cursor *btreeCursor, key uint32, value *tableRow) error {
+	pager := cursor.table.pager
+	oldNode, err := pager.getPageForWrite(cursor.pageNum)
+	if err != nil {
+		return err
+	}
 	oldMax := getNodeMaxKey(oldNode)
 
-	newPageNum := getUnUsedPageNum(cursor.table.pager)
-	newNode := cursor.table.pager.getPage(newPageNum)
+	// 先检查空间是否足够, 出错时不修改任何页面
+	neededPages := uint32(1)
+	if isNodeRoot(oldNode) {
+		neededPages = 2
+	} else {
+		parent, err := pager.getPage(*(*uint32)(nodeParent(oldNode)))
+		if err != nil {
+			return err
+		}
+		if *(*uint32)(internalNodeNumKeys(parent)) >= INTERNAL_NODE_MAX_CELLS {
+			return fmt.Errorf("%w: need to implement splitting internal node", ErrFull)
+		}
+	}
+	if pager.numPages+neededPages > TABLE_MAX_PAGES {
+		return fmt.Errorf("%w: no free pages", ErrFull)
+	}
+
+	newPageNum, err := getUnUsedPageNum(pager)
+	if err != nil {
+		return err
+	}
+	newNode, err := pager.getPageForWrite(newPageNum)
+	if err != nil {
+		return err
+	}
 
 	initializeLeafNode(newNode)
+	pager.splits++
 
 	*(*uint32)(nodeParent(newNode)) = *(*uint32)(nodeParent(oldNode))
 
@@ -169,15 +209,17 @@ func leafNodeSplitAndInsert(cursor *Cursor, key uint32, value *Row) {
 	*(*uint32)(leafNodeNumCells(newNode)) = LEAF_NODE_RIGHT_SPLIT_COUNT
 
 	if isNodeRoot(oldNode) {
-		createNewRoot(cursor.table, newPageNum)
-		return
+		return createNewRoot(cursor.table, newPageNum)
 	} else {
 		parentPageNum := *(*uint32)(nodeParent(oldNode))
 		newMax := getNodeMaxKey(oldNode)
-		parent := cursor.table.pager.getPage(parentPageNum)
+		parent, err := pager.getPageForWrite(parentPageNum)
+		if err != nil {
+			return err
+		}
 
 		updateInternalNodeKey(parent, oldMax, newMax)
-		internalNodeInsert(cursor.table, parentPageNum, newPageNum)
+		return internalNodeInsert(cursor.table, parentPageNum, newPageNum)
 	}
 }
 
@@ -186,26 +228,34 @@ func updateInternalNodeKey(node unsafe.Pointer, oldKey, newKey uint32) {
 	*(*uint32)(internalNodeKey(node, oldChildIndex)) = newKey
 }
 
-func internalNodeInsert(table *Table, parentPageNum, childPageNum uint32) {
-	parent := table.pager.getPage(parentPageNum)
-	child := table.pager.getPage(childPageNum)
+func internalNodeInsert(table *btree, parentPageNum, childPageNum uint32) error {
+	parent, err := table.pager.getPageForWrite(parentPageNum)
+	if err != nil {
+		return err
+	}
+	child, err := table.pager.getPage(childPageNum)
+	if err != nil {
+		return err
+	}
 
 	childMaxKey := getNodeMaxKey(child)
 	index := internalNodeFindChild(parent, childMaxKey)
 
 	originNumKeys := *(*uint32)(internalNodeNumKeys(parent))
-	*(*uint32)(internalNodeNumKeys(parent)) = originNumKeys + 1
-
 	if originNumKeys >= INTERNAL_NODE_MAX_CELLS {
-		fmt.Printf("Need to implement splitting internal node\n")
-		os.Exit(EXIT_FAILURE)
+		return fmt.Errorf("%w: need to implement splitting internal node", ErrFull)
 	}
 
 	rightChildPageNum := *(*uint32)(internalNodeRightChild(parent))
-	rightChild := table.pager.getPage(rightChildPageNum)
+	rightChild, err := table.pager.getPage(rightChildPageNum)
+	if err != nil {
+		return err
+	}
+
+	*(*uint32)(internalNodeNumKeys(parent)) = originNumKeys + 1
 
 	if childMaxKey > getNodeMaxKey(rightChild) {
-		*(*uint32)(internalNodeChild(parent, originNumKeys)) = rightChildPageNum
+		*(*uint32)(internalNodeCell(parent, originNumKeys)) = rightChildPageNum
 		*(*uint32)(internalNodeKey(parent, originNumKeys)) = getNodeMaxKey(rightChild)
 		*(*uint32)(internalNodeRightChild(parent)) = childPageNum
 	} else {
@@ -214,30 +264,46 @@ func internalNodeInsert(table *Table, parentPageNum, childPageNum uint32) {
 			source := internalNodeCell(parent, i-1)
 			copy((*(*[INTERNAL_NODE_CELL_SIZE]byte)(destination))[:], (*(*[INTERNAL_NODE_CELL_SIZE]byte)(source))[:])
 		}
-		*(*uint32)(internalNodeChild(parent, index)) = childPageNum
+		*(*uint32)(internalNodeCell(parent, index)) = childPageNum
 		*(*uint32)(internalNodeKey(parent, index)) = childMaxKey
 	}
 
+	return nil
 }
 
 func nodeParent(node unsafe.Pointer) unsafe.Pointer {
 	return unsafe.Pointer(uintptr(node) + uintptr(PARENT_POINTER_OFFSET))
 }
 
-func createNewRoot(table *Table, rightChildPageNum uint32) {
-	root := table.pager.getPage(table.rootPageNum)
-	rightChild := table.pager.getPage(rightChildPageNum)
+func createNewRoot(table *btree, rightChildPageNum uint32) error {
+	root, err := table.pager.getPageForWrite(table.rootPageNum)
+	if err != nil {
+		return err
+	}
+	rightChild, err := table.pager.getPageForWrite(rightChildPageNum)
+	if err != nil {
+		return err
+	}
 
-	leftChildPageNum := getUnUsedPageNum(table.pager)
-	leftChild := table.pager.getPage(leftChildPageNum)
+	leftChildPageNum, err := getUnUsedPageNum(table.pager)
+	if err != nil {
+		return err
+	}
+	leftChild, err := table.pager.getPageForWrite(leftChildPageNum)
+	if err != nil {
+		return err
+	}
 
 	copy((*(*[PAGE_SIZE]byte)(leftChild))[:], (*(*[PAGE_SIZE]byte)(root))[:])
 	setNodeRoot(leftChild, false)
+	*(*uint32)(catalogRoot(leftChild)) = 0
+	setFileFormat(leftChild, fileFormatLegacy)
+	setChangeCounter(leftChild, 0)
 
 	initializeInternalNode(root)
 	setNodeRoot(root, true)
 	*(*uint32)(internalNodeNumKeys(root)) = 1
-	*(*uint32)(internalNodeChild(root, 0)) = leftChildPageNum
+	*(*uint32)(internalNodeCell(root, 0)) = leftChildPageNum
 
 	leftChildMaxKey := getNodeMaxKey(leftChild)
 	*(*uint32)(internalNodeKey(root, 0)) = leftChildMaxKey
@@ -245,6 +311,7 @@ func createNewRoot(table *Table, rightChildPageNum uint32) {
 
 	*(*uint32)(nodeParent(leftChild)) = table.rootPageNum
 	*(*uint32)(nodeParent(rightChild)) = table.rootPageNum
+	return nil
 }
 
 func update_internal_node_key(node unsafe.Pointer, oldKey, newKey uint32) {
@@ -257,16 +324,19 @@ func setNodeRoot(node unsafe.Pointer, isRoot bool) {
 	*(*bool)(unsafe.Pointer(uintptr(node) + uintptr(IS_ROOT_OFFSET))) = value
 }
 
-func getUnUsedPageNum(pager *Pager) uint32 {
-	return pager.numPages
+func getUnUsedPageNum(pager *filePager) (uint32, error) {
+	if pager.numPages >= TABLE_MAX_PAGES {
+		return 0, fmt.Errorf("%w: no free pages", ErrFull)
+	}
+	return pager.numPages, nil
 }
 
-func getNodeType(node unsafe.Pointer) NodeType {
+func getNodeType(node unsafe.Pointer) nodeType {
 	value := *(*uint8)(unsafe.Pointer(uintptr(node) + uintptr(NODE_TYPE_OFFSET)))
-	return NodeType(value)
+	return nodeType(value)
 }
 
-func setNodeType(node unsafe.Pointer, typ NodeType) {
+func setNodeType(node unsafe.Pointer, typ nodeType) {
 	value := uint8(typ)
 	*(*uint8)(unsafe.Pointer(uintptr(node) + uintptr(NODE_TYPE_OFFSET))) = value
 }
@@ -289,19 +359,21 @@ func leafNodeValue(node unsafe.Pointer, cellNum uint32) unsafe.Pointer {
 }
 
 func initializeLeafNode(node unsafe.Pointer) {
-	setNodeType(node, NODE_LEAF)
+	setNodeType(node, nodeLeaf)
 	setNodeRoot(node, false)
 	*(*uint32)(leafNodeNumCells(node)) = 0
 	*(*uint32)(leafNodeNextLeaf(node)) = 0
 }
 
-func leafNodeInsert(cursor *Cursor, key uint32, value *Row) {
-	node := cursor.table.pager.getPage(cursor.pageNum)
+func leafNodeInsert(cursor *btreeCursor, key uint32, value *tableRow) error {
+	node, err := cursor.table.pager.getPageForWrite(cursor.pageNum)
+	if err != nil {
+		return err
+	}
 
 	numCells := *(*uint32)(leafNodeNumCells(node))
 	if numCells >= LEAF_NODE_MAX_CELLS {
-		leafNodeSplitAndInsert(cursor, key, value)
-		return
+		return leafNodeSplitAndInsert(cursor, key, value)
 	}
 
 	if cursor.cellNum < numCells {
@@ -314,41 +386,46 @@ func leafNodeInsert(cursor *Cursor, key uint32, value *Row) {
 	*(*uint32)(leafNodeNumCells(node)) += 1
 	*(*uint32)(leafNodeKey(node, cursor.cellNum)) = key
 	serializeRow(value, leafNodeValue(node, cursor.cellNum))
+	return nil
 }
 
-func indent(level uint32) {
+func indent(w io.Writer, level uint32) {
 	for i := uint32(0); i < level; i++ {
-		fmt.Printf("  ")
+		fmt.Fprintf(w, "  ")
 	}
 }
 
-func printTree(pager *Pager, pageNum uint32, indentationLevel uint32) {
-	node := pager.getPage(pageNum)
+func printTree(w io.Writer, pager *filePager, pageNum uint32, indentationLevel uint32) error {
+	node, err := pager.getPage(pageNum)
+	if err != nil {
+		return err
+	}
 	var numKeys, child uint32
 
 	switch getNodeType(node) {
-	case NODE_INTERNAL:
+	case nodeInternal:
 		numKeys = *(*uint32)(internalNodeNumKeys(node))
-		indent(indentationLevel)
-		fmt.Printf("- internal (size %d)\n", numKeys)
+		indent(w, indentationLevel)
+		fmt.Fprintf(w, "- internal (size %d)\n", numKeys)
 		for i := uint32(0); i < numKeys; i++ {
-			child = *(*uint32)(internalNodeChild(node, i))
-			printTree(pager, child, indentationLevel+1)
+			child = *(*uint32)(internalNodeCell(node, i))
+			if err := printTree(w, pager, child, indentationLevel+1); err != nil {
+				return err
+			}
 
-			indent(indentationLevel + 1)
-			fmt.Printf("- key %d\n", *(*uint32)(internalNodeKey(node, i)))
+			indent(w, indentationLevel+1)
+			fmt.Fprintf(w, "- key %d\n", *(*uint32)(internalNodeKey(node, i)))
 		}
 		child = *(*uint32)(internalNodeRightChild(node))
-		printTree(pager, child, indentationLevel+1)
-		break
-	case NODE_LEAF:
+		return printTree(w, pager, child, indentationLevel+1)
+	case nodeLeaf:
 		numKeys = *(*uint32)(leafNodeNumCells(node))
-		indent(indentationLevel)
-		fmt.Printf("- leaf (size %d)\n", numKeys)
+		indent(w, indentationLevel)
+		fmt.Fprintf(w, "- leaf (size %d)\n", numKeys)
 		for i := uint32(0); i < numKeys; i++ {
-			indent(indentationLevel + 1)
-			fmt.Printf("- %d\n", *(*uint32)(leafNodeKey(node, i)))
+			indent(w, indentationLevel+1)
+			fmt.Fprintf(w, "- %d\n", *(*uint32)(leafNodeKey(node, i)))
 		}
-		break
 	}
+	return nil
 }
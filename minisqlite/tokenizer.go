@@ -0,0 +1,187 @@
+package minisqlite
+
+import (
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenParameter
+)
+
+type sqlToken struct {
+	typ  tokenType
+	text string
+	pos  int
+	end  int
+}
+
+var twoCharOperators = []string{"<=", ">=", "<>", "!=", "==", "||"}
+
+const singleCharOperators = "=<>+-*/%(),.;"
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// tokenize 把语句切分成token, 遇到无法识别的字符或未闭合的字符串时返回false
+func tokenize(input string) ([]sqlToken, bool) {
+	var tokens []sqlToken
+
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentifierStart(c):
+			for i < len(input) && isIdentifierChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{typ: tokenIdentifier, text: input[start:i], pos: start, end: i})
+		case isDigit(c):
+			for i < len(input) && isDigit(input[i]) {
+				i++
+			}
+			if i+1 < len(input) && input[i] == '.' && isDigit(input[i+1]) {
+				i++
+				for i < len(input) && isDigit(input[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, sqlToken{typ: tokenNumber, text: input[start:i], pos: start, end: i})
+		case c == '?' || (c == ':' && i+1 < len(input) && isIdentifierStart(input[i+1])):
+			// ?, ?NNN 或 :name
+			i++
+			for i < len(input) && isIdentifierChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{typ: tokenParameter, text: input[start:i], pos: start, end: i})
+		case c == '\'':
+			// 字符串中的''是转义的单引号
+			var text strings.Builder
+			i++
+			closed := false
+			for i < len(input) {
+				if input[i] == '\'' {
+					if i+1 < len(input) && input[i+1] == '\'' {
+						text.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				text.WriteByte(input[i])
+				i++
+			}
+			if !closed {
+				return nil, false
+			}
+			tokens = append(tokens, sqlToken{typ: tokenString, text: text.String(), pos: start, end: i})
+		default:
+			matched := false
+			for _, op := range twoCharOperators {
+				if strings.HasPrefix(input[i:], op) {
+					i += len(op)
+					tokens = append(tokens, sqlToken{typ: tokenOperator, text: op, pos: start, end: i})
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+			if strings.IndexByte(singleCharOperators, c) < 0 {
+				return nil, false
+			}
+			i++
+			tokens = append(tokens, sqlToken{typ: tokenOperator, text: input[start:i], pos: start, end: i})
+		}
+	}
+
+	tokens = append(tokens, sqlToken{typ: tokenEOF, pos: len(input), end: len(input)})
+	return tokens, true
+}
+
+type sqlParser struct {
+	input      string
+	tokens     []sqlToken
+	pos        int
+	parameters *parameterList
+}
+
+func newParser(input string) (*sqlParser, bool) {
+	tokens, ok := tokenize(input)
+	if !ok {
+		return nil, false
+	}
+	return &sqlParser{input: input, tokens: tokens}, true
+}
+
+func (parser *sqlParser) peek() sqlToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *sqlParser) next() sqlToken {
+	token := parser.tokens[parser.pos]
+	if token.typ != tokenEOF {
+		parser.pos++
+	}
+	return token
+}
+
+func (parser *sqlParser) atEnd() bool {
+	return parser.peek().typ == tokenEOF
+}
+
+func (parser *sqlParser) isKeyword(keyword string) bool {
+	token := parser.peek()
+	return token.typ == tokenIdentifier && strings.EqualFold(token.text, keyword)
+}
+
+func (parser *sqlParser) acceptKeyword(keyword string) bool {
+	if parser.isKeyword(keyword) {
+		parser.next()
+		return true
+	}
+	return false
+}
+
+func (parser *sqlParser) isOperator(op string) bool {
+	token := parser.peek()
+	return token.typ == tokenOperator && token.text == op
+}
+
+func (parser *sqlParser) acceptOperator(op string) bool {
+	if parser.isOperator(op) {
+		parser.next()
+		return true
+	}
+	return false
+}
+
+// textSince 返回从第start个token开始到当前位置的原始语句文本
+func (parser *sqlParser) textSince(start int) string {
+	if parser.pos <= start {
+		return ""
+	}
+	return parser.input[parser.tokens[start].pos:parser.tokens[parser.pos-1].end]
+}
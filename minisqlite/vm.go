@@ -0,0 +1,418 @@
+package minisqlite
+
+import (
+	"fmt"
+	"math"
+)
+
+// vmOpcode 虚拟机的指令, 参考SQLite的VDBE. 每条指令有P1 P2 P3三个整数操作数和一个任意类型的P4,
+// 跳转指令的目标地址都在P2中
+type vmOpcode int
+
+const (
+	// opHalt 结束程序
+	opHalt vmOpcode = iota
+	// opGoto 跳转到P2
+	opGoto
+	// opTransaction 开始, 提交或回滚事务, P1为statementBegin statementCommit或statementRollback
+	opTransaction
+	// opCreateTable 创建名为P4的表
+	opCreateTable
+	// opAnalyze 统计名为P4的表写入sqlite_stat1, P4为空时统计所有表
+	opAnalyze
+	// opOpenRead 游标P1打开名为P4的表, 表不存在时返回EXECUTE_UNKNOWN_TABLE
+	opOpenRead
+	// opOpenWrite 同opOpenRead, 系统表不能写入
+	opOpenWrite
+	// opRewind 游标P1移到表的第一行, 表为空时跳转到P2
+	opRewind
+	// opNext 游标P1移到下一行, 还有行时跳转到P2
+	opNext
+	// opSeekRowid 游标P1按寄存器P3中的主键查找, 找不到时跳转到P2
+	opSeekRowid
+	// opSeekGe 游标P1移到主键大于等于寄存器P3的第一行, 没有时跳转到P2
+	opSeekGe
+	// opHashBuild 第一次执行时扫描游标P1的表, 以表达式P4为键建立哈希表.
+	// 求值P4时表的列位于连接行中第P1组
+	opHashBuild
+	// opHashProbe 游标P1移到哈希表中键等于寄存器P3的第一行, 没有时跳转到P2
+	opHashProbe
+	// opHashNext 游标P1移到下一个键相同的行, 还有行时跳转到P2
+	opHashNext
+	// opNullRow 游标P1之后读出的列都是NULL, 之后的opNext和opHashNext不再跳转, 用于LEFT JOIN
+	opNullRow
+	// opColumn 读取游标P1当前行的第P2列到寄存器P3
+	opColumn
+	// opInteger 寄存器P3设为整数P1
+	opInteger
+	// opValue 寄存器P3设为常量P4
+	opValue
+	// opCopy 寄存器P1复制到寄存器P3
+	opCopy
+	// opExpr 对寄存器0开始的连接行求值表达式P4, 结果存入寄存器P3
+	opExpr
+	// opIf 寄存器P1为真时跳转到P2
+	opIf
+	// opIfNot 寄存器P1为假或NULL时跳转到P2
+	opIfNot
+	// opEq opNe opLt opLe opGt opGe 比较寄存器P1和P3, 比较结果为假或NULL时跳转到P2
+	opEq
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	// opResultRow 输出寄存器P1开始的P2个寄存器作为一个结果行
+	opResultRow
+	// opAggStep 把寄存器0开始的连接行加入聚合, P4为查询
+	opAggStep
+	// opAggFinal 输出聚合中每个分组的结果行, P4为查询
+	opAggFinal
+	// opInsert 把寄存器P2开始的id, username, email插入游标P1的表
+	opInsert
+)
+
+type instruction struct {
+	opcode vmOpcode
+	p1     int
+	p2     int
+	p3     int
+	p4     interface{}
+}
+
+// vmProgram 编译后的语句, 连接行保存在寄存器0开始的width个寄存器中, 每张表占len(tableColumns)个
+type vmProgram struct {
+	instructions []instruction
+	numRegisters int
+	numCursors   int
+	width        int
+	// cursorNames 游标对应的表, 有别名时为 "table AS alias", 用于explain
+	cursorNames []string
+	// plan 编译select时记录的每层循环的访问方式, 用于explain query plan
+	plan []planStep
+}
+
+// planStep 查询计划中的一步, addr 为开始执行这一步的指令地址
+type planStep struct {
+	addr   int
+	detail string
+}
+
+// vmCursor 程序中打开的表, row 为当前行的值, 为nil时从cursor中读取
+type vmCursor struct {
+	table   *btree
+	cursor  *btreeCursor
+	row     []Value
+	nullRow bool
+
+	// opHashBuild 建立的哈希表, matches 为当前键的所有行
+	hash    map[string][][]Value
+	matches [][]Value
+	match   int
+}
+
+// virtualMachine 执行一个vmProgram, 每次执行使用新的寄存器和游标
+type virtualMachine struct {
+	program    *vmProgram
+	statement  *sqlStatement
+	pager      *filePager
+	registers  []Value
+	cursors    []*vmCursor
+	aggregator *hashAggregator
+
+	// pc 下一条要执行的指令, step在结果行处返回, 下次从这里继续
+	pc int
+	// pending opAggFinal输出的结果行, 由之后的step逐行返回
+	pending [][]Value
+}
+
+// start 准备执行程序, 之后每次调用step取得一个结果行. 调用方负责加锁和自动提交
+func (program *vmProgram) start(statement *sqlStatement, table *btree) *virtualMachine {
+	return &virtualMachine{
+		program:   program,
+		statement: statement,
+		pager:     table.pager,
+		registers: make([]Value, program.numRegisters),
+		cursors:   make([]*vmCursor, program.numCursors),
+	}
+}
+
+// run 执行完整个程序, 每个结果行调用一次output
+func (program *vmProgram) run(statement *sqlStatement, table *btree, output func(values []Value)) error {
+	vm := program.start(statement, table)
+	defer vm.close()
+	for {
+		row, err := vm.step()
+		if err != nil || row == nil {
+			return err
+		}
+		output(row)
+	}
+}
+
+// close 删除聚合还没有处理的临时文件
+func (vm *virtualMachine) close() {
+	if vm.aggregator != nil {
+		vm.aggregator.close()
+	}
+}
+
+// step 执行到下一个结果行并返回它, 程序结束时返回nil
+func (vm *virtualMachine) step() ([]Value, error) {
+	if len(vm.pending) > 0 {
+		row := vm.pending[0]
+		vm.pending = vm.pending[1:]
+		return row, nil
+	}
+
+	instructions := vm.program.instructions
+	registers := vm.registers
+
+	for vm.pc < len(instructions) {
+		in := &instructions[vm.pc]
+		vm.pc++
+
+		switch in.opcode {
+		case opHalt:
+			vm.pc = len(instructions)
+			return nil, nil
+		case opGoto:
+			vm.pc = in.p2
+		case opTransaction:
+			if err := executeTransaction(statementType(in.p1), vm.pager); err != nil {
+				return nil, err
+			}
+		case opCreateTable:
+			if err := executeCreateTable(vm.pager, in.p4.(string)); err != nil {
+				return nil, err
+			}
+		case opAnalyze:
+			if err := executeAnalyze(vm.pager, in.p4.(string)); err != nil {
+				return nil, err
+			}
+		case opOpenRead, opOpenWrite:
+			table, err := findTable(vm.pager, in.p4.(string))
+			if err != nil {
+				return nil, err
+			}
+			if table == nil {
+				return nil, EXECUTE_UNKNOWN_TABLE
+			}
+			if in.opcode == opOpenWrite && table.name == catalogTableName {
+				return nil, EXECUTE_READONLY_TABLE
+			}
+			vm.cursors[in.p1] = &vmCursor{table: table}
+		case opRewind:
+			cursor := vm.cursors[in.p1]
+			c, err := tableStart(cursor.table)
+			if err != nil {
+				return nil, err
+			}
+			cursor.cursor, cursor.row, cursor.nullRow = c, nil, false
+			if c.endOfTable {
+				vm.pc = in.p2
+			}
+		case opNext:
+			cursor := vm.cursors[in.p1]
+			if cursor.nullRow {
+				break
+			}
+			if err := cursor.cursor.cursorAdvance(); err != nil {
+				return nil, err
+			}
+			cursor.row = nil
+			if !cursor.cursor.endOfTable {
+				vm.pc = in.p2
+			}
+		case opSeekRowid:
+			cursor := vm.cursors[in.p1]
+			cursor.nullRow = false
+			found, err := vm.seekRowid(cursor, registers[in.p3])
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				vm.pc = in.p2
+			}
+		case opSeekGe:
+			cursor := vm.cursors[in.p1]
+			found, err := vm.seekGE(cursor, registers[in.p3])
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				vm.pc = in.p2
+			}
+		case opHashBuild:
+			cursor := vm.cursors[in.p1]
+			if cursor.hash == nil {
+				hash, err := buildHash(cursor.table, in.p4.(*exprNode), in.p1*len(tableColumns))
+				if err != nil {
+					return nil, err
+				}
+				cursor.hash = hash
+			}
+		case opHashProbe:
+			cursor := vm.cursors[in.p1]
+			cursor.nullRow, cursor.matches, cursor.match = false, nil, 0
+			if key := registers[in.p3]; key.typ != valueNull {
+				cursor.matches = cursor.hash[hashKey(key)]
+			}
+			if len(cursor.matches) == 0 {
+				vm.pc = in.p2
+			} else {
+				cursor.row = cursor.matches[0]
+			}
+		case opHashNext:
+			cursor := vm.cursors[in.p1]
+			if cursor.nullRow {
+				break
+			}
+			cursor.match++
+			if cursor.match < len(cursor.matches) {
+				cursor.row = cursor.matches[cursor.match]
+				vm.pc = in.p2
+			}
+		case opNullRow:
+			cursor := vm.cursors[in.p1]
+			cursor.nullRow = true
+			cursor.row = make([]Value, len(tableColumns))
+		case opColumn:
+			values, err := vm.cursors[in.p1].values()
+			if err != nil {
+				return nil, err
+			}
+			registers[in.p3] = values[in.p2]
+		case opInteger:
+			registers[in.p3] = integerValue(int64(in.p1))
+		case opValue:
+			registers[in.p3] = in.p4.(Value)
+		case opCopy:
+			registers[in.p3] = registers[in.p1]
+		case opExpr:
+			registers[in.p3] = evalExpr(in.p4.(*exprNode), registers[:vm.program.width], nil)
+		case opIf:
+			if isTruthy(registers[in.p1]) {
+				vm.pc = in.p2
+			}
+		case opIfNot:
+			if !isTruthy(registers[in.p1]) {
+				vm.pc = in.p2
+			}
+		case opEq, opNe, opLt, opLe, opGt, opGe:
+			if !compareRegisters(in.opcode, registers[in.p1], registers[in.p3]) {
+				vm.pc = in.p2
+			}
+		case opResultRow:
+			return append([]Value(nil), registers[in.p1:in.p1+in.p2]...), nil
+		case opAggStep:
+			if vm.aggregator == nil {
+				vm.aggregator = newHashAggregator(in.p4.(*selectStatement), 0)
+			}
+			if err := vm.aggregator.add(registers[:vm.program.width]); err != nil {
+				return nil, err
+			}
+		case opAggFinal:
+			if vm.aggregator == nil {
+				vm.aggregator = newHashAggregator(in.p4.(*selectStatement), 0)
+			}
+			// 分组的结果行先全部取出, 再由step逐行返回
+			err := vm.aggregator.finish(func(row []Value) {
+				vm.pending = append(vm.pending, row)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(vm.pending) > 0 {
+				return vm.step()
+			}
+		case opInsert:
+			if err := bindInsertValues(vm.statement, registers[in.p2:in.p2+len(tableColumns)]); err != nil {
+				return nil, err
+			}
+			if err := insertRow(vm.cursors[in.p1].table, &vm.statement.rowToInsert); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown opcode %d at %d", in.opcode, vm.pc-1)
+		}
+	}
+	return nil, nil
+}
+
+// values 返回游标当前行的值, 从B树中读出的行只解码一次
+func (cursor *vmCursor) values() ([]Value, error) {
+	if cursor.row == nil {
+		var row tableRow
+		if err := cursor.cursor.cursorRow(&row); err != nil {
+			return nil, err
+		}
+		cursor.row = rowValues(&row)
+	}
+	return cursor.row, nil
+}
+
+// seekRowid 主键只能是uint32范围内的整数, 其他值找不到任何行
+func (vm *virtualMachine) seekRowid(cursor *vmCursor, key Value) (bool, error) {
+	key = key.numeric()
+	if key.typ == valueReal && key.real == math.Trunc(key.real) {
+		key = integerValue(int64(key.real))
+	}
+	if key.typ != valueInteger || key.integer < 0 || key.integer > math.MaxUint32 {
+		return false, nil
+	}
+
+	var found tableRow
+	ok, err := tableLookup(cursor.table, uint32(key.integer), &found)
+	if err != nil || !ok {
+		return false, err
+	}
+	cursor.row = rowValues(&found)
+	return true, nil
+}
+
+// seekGE 按compareValues的顺序, NULL 和非数字的文本没有小于等于它的主键
+func (vm *virtualMachine) seekGE(cursor *vmCursor, key Value) (bool, error) {
+	cursor.row, cursor.nullRow = nil, false
+	if key.typ == valueNull || (key.typ == valueText && !looksNumeric(key.text)) {
+		return false, nil
+	}
+
+	key = key.numeric()
+	bound := float64(key.integer)
+	if key.typ == valueReal {
+		bound = math.Ceil(key.real)
+	}
+	if bound > math.MaxUint32 {
+		return false, nil
+	}
+
+	c, err := tableSeek(cursor.table, uint32(math.Max(bound, 0)))
+	if err != nil {
+		return false, err
+	}
+	cursor.cursor = c
+	return !c.endOfTable, nil
+}
+
+// compareRegisters 和evalBinary中的比较相同, 有NULL时结果为NULL, 不为真
+func compareRegisters(opcode vmOpcode, left, right Value) bool {
+	if left.typ == valueNull || right.typ == valueNull {
+		return false
+	}
+	cmp := compareValues(left, right)
+	switch opcode {
+	case opEq:
+		return cmp == 0
+	case opNe:
+		return cmp != 0
+	case opLt:
+		return cmp < 0
+	case opLe:
+		return cmp <= 0
+	case opGt:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"sqlite/minisqlite"
+)
+
+// OutputMode 查询结果的输出格式
+type OutputMode int
+
+const (
+	MODE_TUPLE OutputMode = iota
+	MODE_CSV
+	MODE_TAB
+	MODE_JSON
+	MODE_JSONLINES
+	MODE_TABLE
+	MODE_LINE
+	MODE_MARKDOWN
+)
+
+var modeNames = []string{"tuple", "csv", "tab", "json", "jsonlines", "table", "line", "markdown"}
+
+func (mode OutputMode) String() string {
+	return modeNames[mode]
+}
+
+// OutputSettings 由 .mode .headers .nullvalue .output .once 修改
+type OutputSettings struct {
+	mode    OutputMode
+	headers bool
+	// nullValue NULL在文本格式中的显示, json中总是null
+	nullValue string
+
+	// file 为nil时输出到标准输出, once 表示下一条语句之后恢复标准输出
+	file   *os.File
+	writer *bufio.Writer
+	once   bool
+}
+
+func newOutputSettings() *OutputSettings {
+	return &OutputSettings{mode: MODE_TUPLE, nullValue: "NULL"}
+}
+
+// out 查询结果和.dump的输出位置
+func (settings *OutputSettings) out() io.Writer {
+	if settings.writer != nil {
+		return settings.writer
+	}
+	return os.Stdout
+}
+
+// redirect 之后的输出写到path, 先关闭之前打开的文件
+func (settings *OutputSettings) redirect(path string, once bool) error {
+	if err := settings.closeOutput(); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	settings.file = file
+	settings.writer = bufio.NewWriter(file)
+	settings.once = once
+	return nil
+}
+
+// finishStatement 每条语句输出完成后调用, 写回缓冲区, .once的文件在这里关闭
+func (settings *OutputSettings) finishStatement() error {
+	if settings.writer == nil {
+		return nil
+	}
+	if settings.once {
+		return settings.closeOutput()
+	}
+	return settings.writer.Flush()
+}
+
+// closeOutput 写回并关闭文件, 恢复到标准输出
+func (settings *OutputSettings) closeOutput() error {
+	if settings.file == nil {
+		return nil
+	}
+	err := settings.writer.Flush()
+	if closeErr := settings.file.Close(); err == nil {
+		err = closeErr
+	}
+	settings.file = nil
+	settings.writer = nil
+	settings.once = false
+	return err
+}
+
+// printRows 按当前格式输出全部结果行, 没有结果行时什么也不输出.
+// 取结果行时出错则什么也不输出, 返回错误
+func printRows(w io.Writer, settings *OutputSettings, rows *minisqlite.Rows) error {
+	columns := rows.Columns()
+	var values [][]minisqlite.Value
+	for rows.Next() {
+		values = append(values, rows.Values())
+	}
+	if err := rows.Err(); err != nil || len(values) == 0 {
+		return err
+	}
+
+	switch settings.mode {
+	case MODE_TUPLE:
+		if settings.headers {
+			fmt.Fprintf(w, "(%s)\n", strings.Join(columns, ", "))
+		}
+		for _, row := range values {
+			fmt.Fprintf(w, "(%s)\n", strings.Join(settings.texts(row), ", "))
+		}
+	case MODE_CSV:
+		writer := csv.NewWriter(w)
+		if settings.headers {
+			writer.Write(columns)
+		}
+		for _, row := range values {
+			writer.Write(settings.texts(row))
+		}
+		writer.Flush()
+	case MODE_TAB:
+		if settings.headers {
+			fmt.Fprintf(w, "%s\n", strings.Join(columns, "\t"))
+		}
+		for _, row := range values {
+			fmt.Fprintf(w, "%s\n", strings.Join(settings.texts(row), "\t"))
+		}
+	case MODE_JSON:
+		for i, row := range values {
+			prefix, suffix := "", ",\n"
+			if i == 0 {
+				prefix = "["
+			}
+			if i == len(values)-1 {
+				suffix = "]\n"
+			}
+			fmt.Fprintf(w, "%s%s%s", prefix, jsonObject(columns, row), suffix)
+		}
+	case MODE_JSONLINES:
+		for _, row := range values {
+			fmt.Fprintf(w, "%s\n", jsonObject(columns, row))
+		}
+	case MODE_TABLE:
+		printBox(w, columns, settings.textRows(values))
+	case MODE_LINE:
+		width := 0
+		for _, column := range columns {
+			if n := utf8.RuneCountInString(column); n > width {
+				width = n
+			}
+		}
+		for i, row := range values {
+			if i > 0 {
+				fmt.Fprintf(w, "\n")
+			}
+			for j, text := range settings.texts(row) {
+				fmt.Fprintf(w, "%*s = %s\n", width, columns[j], text)
+			}
+		}
+	case MODE_MARKDOWN:
+		printMarkdown(w, columns, settings.textRows(values))
+	}
+	return nil
+}
+
+// texts 文本格式中每个值的显示
+func (settings *OutputSettings) texts(row []minisqlite.Value) []string {
+	texts := make([]string, len(row))
+	for i, value := range row {
+		texts[i] = value.String()
+		if value.IsNull() {
+			texts[i] = settings.nullValue
+		}
+	}
+	return texts
+}
+
+func (settings *OutputSettings) textRows(values [][]minisqlite.Value) [][]string {
+	rows := make([][]string, len(values))
+	for i, row := range values {
+		rows[i] = settings.texts(row)
+	}
+	return rows
+}
+
+// jsonObject {"列名":值,...}, 按列的顺序输出
+func jsonObject(columns []string, row []minisqlite.Value) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, value := range row {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(jsonString(columns[i]))
+		b.WriteString(":")
+		switch v := value.Interface().(type) {
+		case int64:
+			b.WriteString(value.String())
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				b.WriteString("null")
+			} else {
+				b.WriteString(value.String())
+			}
+		case string:
+			b.WriteString(jsonString(v))
+		default:
+			b.WriteString("null")
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// jsonString 不转义 < > &
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// columnWidths 每列最宽的内容, 按字符计算
+func columnWidths(columns []string, rows [][]string) []int {
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column)
+	}
+	for _, row := range rows {
+		for i, text := range row {
+			if n := utf8.RuneCountInString(text); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	return widths
+}
+
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+func printBox(w io.Writer, columns []string, rows [][]string) {
+	widths := columnWidths(columns, rows)
+	border := func(left, middle, right string) {
+		segments := make([]string, len(widths))
+		for i, width := range widths {
+			segments[i] = strings.Repeat("─", width+2)
+		}
+		fmt.Fprintf(w, "%s%s%s\n", left, strings.Join(segments, middle), right)
+	}
+	line := func(texts []string) {
+		cells := make([]string, len(texts))
+		for i, text := range texts {
+			cells[i] = pad(text, widths[i])
+		}
+		fmt.Fprintf(w, "│ %s │\n", strings.Join(cells, " │ "))
+	}
+
+	border("┌", "┬", "┐")
+	line(columns)
+	border("├", "┼", "┤")
+	for _, row := range rows {
+		line(row)
+	}
+	border("└", "┴", "┘")
+}
+
+func printMarkdown(w io.Writer, columns []string, rows [][]string) {
+	escape := func(texts []string) []string {
+		escaped := make([]string, len(texts))
+		for i, text := range texts {
+			escaped[i] = strings.ReplaceAll(text, "|", "\\|")
+		}
+		return escaped
+	}
+	columns = escape(columns)
+	for i := range rows {
+		rows[i] = escape(rows[i])
+	}
+
+	widths := columnWidths(columns, rows)
+	line := func(texts []string) {
+		cells := make([]string, len(texts))
+		for i, text := range texts {
+			cells[i] = pad(text, widths[i])
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+
+	line(columns)
+	separators := make([]string, len(widths))
+	for i, width := range widths {
+		separators[i] = strings.Repeat("-", width)
+	}
+	fmt.Fprintf(w, "|-%s-|\n", strings.Join(separators, "-|-"))
+	for _, row := range rows {
+		line(row)
+	}
+}
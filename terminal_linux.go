@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// 读取和设置终端属性的ioctl请求
+const (
+	IOCTL_GET_TERMIOS = syscall.TCGETS
+	IOCTL_SET_TERMIOS = syscall.TCSETS
+)
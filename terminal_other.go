@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// 其他平台没有实现终端的raw mode, 按行读取输入
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (func() error, error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}